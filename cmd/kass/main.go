@@ -1,28 +1,86 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 
+	"github.com/evesfect/k-assist/internal/chat"
 	"github.com/evesfect/k-assist/internal/config"
+	"github.com/evesfect/k-assist/internal/contextbuilder"
 	"github.com/evesfect/k-assist/internal/dirutil"
+	"github.com/evesfect/k-assist/internal/gitinfo"
+	"github.com/evesfect/k-assist/internal/helptext"
 	"github.com/evesfect/k-assist/internal/llm"
+	"github.com/evesfect/k-assist/internal/progress"
+	"github.com/evesfect/k-assist/internal/project"
+	"github.com/evesfect/k-assist/internal/redact"
 	"github.com/evesfect/k-assist/internal/shell"
+	"github.com/evesfect/k-assist/internal/sysinfo"
+	"github.com/evesfect/k-assist/internal/tools"
 )
 
 func main() {
+	// Print shell integration script: kass init bash|zsh|fish
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if len(os.Args) != 3 {
+			log.Fatal("Usage: kass init bash|zsh|fish")
+		}
+		script, err := shell.InitScript(os.Args[2])
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Print(script)
+		return
+	}
+
+	// Interactive chat: kass chat [-resume name] [-list]
+	if len(os.Args) > 1 && os.Args[1] == "chat" {
+		runChat(os.Args[2:])
+		return
+	}
+
 	// Define flags
 	codeFlag := flag.Bool("c", false, "Get code-related information")
 	allFlag := flag.Bool("a", false, "Include all subdirectories and files")
 	allContentFlag := flag.Bool("A", false, "Include all subdirectories and files with their contents")
+	relevantFlag := flag.Bool("r", false, "Include the contents of the files most relevant to the prompt")
+	gitFlag := flag.Bool("g", false, "Include the git branch, status, diff and recent commits")
+	helpFlag := flag.Bool("m", false, "Include the --help or man page of programs named in the prompt")
+	var includeGlobs, excludeGlobs stringList
+	flag.Var(&includeGlobs, "include", "With -r, always include files matching this glob (repeatable)")
+	flag.Var(&excludeGlobs, "exclude", "With -r, never include files matching this glob (repeatable)")
+	printFlag := flag.Bool("n", false, "Print suggested commands to stdout and exit without running them")
+	flag.BoolVar(printFlag, "print", false, "Same as -n")
+	jsonFlag := flag.Bool("json", false, "With -n, print suggestions as JSON")
+	showRedactionsFlag := flag.Bool("show-redactions", false, "Report secrets that were removed before sending data to the LLM")
 	flag.Parse()
 
 	// Validate flag combinations
 	if *allFlag && *allContentFlag {
 		log.Fatal("Error: Cannot use both -a and -A flags together")
 	}
+	if *relevantFlag && (*allFlag || *allContentFlag) {
+		log.Fatal("Error: Cannot use -r with -a or -A")
+	}
+	if (len(includeGlobs) > 0 || len(excludeGlobs) > 0) && !*relevantFlag {
+		log.Fatal("Error: --include and --exclude can only be used with -r")
+	}
+	if *jsonFlag && !*printFlag {
+		log.Fatal("Error: -json can only be used with -n/--print")
+	}
+	if *printFlag && *codeFlag {
+		log.Fatal("Error: Cannot use -n/--print with -c")
+	}
 
 	// Check prompt
 	if flag.NArg() < 1 {
@@ -38,11 +96,19 @@ func main() {
 		logger.Fatalf("Error getting current directory: %v", err)
 	}
 
-	// List directory contents
+	// Load configuration and describe the environment to the LLM
+	cfg, system, redactor := setup(logger, currentDir, *showRedactionsFlag)
+	assist := func(logger *log.Logger, llmClient llm.Client, cfg *config.Config, failure llm.Failure) {
+		// The failed command may have been stopped with Ctrl-C, so assistance listens for it anew
+		ctx, stop := interruptible()
+		defer stop()
+		handleErrorWithAssistance(ctx, logger, llmClient, cfg, redactor, failure)
+	}
+
+	// Collect directory information, budgeted to fit the model's context window
+	builder := contextbuilder.New(cfg)
 	var dirInfo string
-	if *allContentFlag {
-		dirInfo, err = dirutil.GetAllDirectoryContentsWithData(currentDir)
-	} else if *allFlag {
+	if *allFlag || *allContentFlag || *relevantFlag {
 		dirInfo, err = dirutil.GetAllDirectoryContents(currentDir)
 	} else {
 		dirInfo, err = dirutil.GetCurrentDirectoryContents(currentDir)
@@ -50,11 +116,68 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Error reading directory contents: %v", err)
 	}
+	builder.Add(contextbuilder.Section{
+		Share: 1,
+		Parts: []contextbuilder.Part{{Name: "directory listing", Text: dirInfo, Cut: contextbuilder.KeepStart}},
+	})
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		logger.Fatalf("Error loading config: %v", err)
+	if *allContentFlag {
+		files, omitted, err := dirutil.ReadFiles(currentDir, dirutil.ContentLimits{
+			MaxFileBytes:  cfg.Context.MaxFileBytes,
+			MaxTotalBytes: cfg.Context.MaxTotalBytes,
+		}, redactor)
+		if err != nil {
+			logger.Fatalf("Error reading directory contents: %v", err)
+		}
+		builder.Add(fileSection(files, omitted, false))
+	} else if *relevantFlag {
+		files, omitted, err := dirutil.RelevantFiles(currentDir, dirutil.Selection{
+			Prompt:   flag.Arg(0),
+			Include:  includeGlobs,
+			Exclude:  excludeGlobs,
+			MaxFiles: cfg.Context.MaxFiles,
+		}, dirutil.ContentLimits{
+			MaxFileBytes:  cfg.Context.MaxFileBytes,
+			MaxTotalBytes: cfg.Context.MaxTotalBytes,
+		}, redactor)
+		if err != nil {
+			logger.Fatalf("Error selecting relevant files: %v", err)
+		}
+		if len(files) == 0 {
+			logger.Printf("No relevant files found, use --include to pick files")
+		}
+		for _, file := range files {
+			logger.Printf("Including %s (%s)", file.Path, file.Reason)
+		}
+		builder.Add(fileSection(files, omitted, true))
+	}
+
+	if *gitFlag {
+		info, err := gitinfo.Collect(currentDir)
+		if errors.Is(err, gitinfo.ErrNotRepository) {
+			logger.Fatalf("Error: -g can only be used inside a git repository")
+		}
+		if err != nil {
+			logger.Fatalf("Error reading git repository: %v", err)
+		}
+		builder.Add(gitSection(info))
+	}
+
+	// Look up the usage of the programs the prompt names, to check suggested flags against
+	docs := make(map[string]string)
+	if *helpFlag {
+		for _, name := range helptext.Mentioned(flag.Arg(0)) {
+			doc, err := helptext.Lookup(name)
+			if err != nil {
+				logger.Printf("Warning: Could not get help for %s: %v", name, err)
+				continue
+			}
+			docs[name] = doc
+		}
+		if len(docs) == 0 {
+			logger.Printf("No installed programs found in the prompt")
+		}
+		builder.Add(helpSection(docs))
 	}
 
 	// Create LLM client
@@ -67,35 +190,272 @@ func main() {
 	prompt := flag.Arg(0)
 
 	// Add current directory information to the prompt
-	prompt = dirInfo + "\n" + prompt
+	prompt = redactor.Redact("prompt", builder.Build(prompt))
+
+	// Ctrl-C cancels waiting for the LLM instead of killing kass
+	ctx, stop := interruptible()
+	defer stop()
 
 	if *codeFlag {
-		response, err := llmClient.GetResponse(prompt)
+		// Print the response as it is generated
+		spinner := progress.Start("Waiting for " + cfg.LLM.Provider)
+		err := llmClient.StreamResponse(ctx, prompt, spinner.Writer(os.Stdout))
+		spinner.Stop()
+		fmt.Println()
 		if err != nil {
-			logger.Printf("Error getting response from LLM: %v", err)
-			handleErrorWithAssistance(logger, llmClient, cfg, err.Error())
-			return
+			reportLLMError(logger, "getting response from LLM", err)
+			os.Exit(1)
 		}
-		fmt.Println(response)
 	} else {
-		command, err := llmClient.GetCommand(prompt)
+		spinner := progress.Start("Waiting for " + cfg.LLM.Provider)
+		suggestions, err := llmClient.GetCommand(ctx, prompt)
+		spinner.Stop()
 		if err != nil {
-			logger.Printf("Error getting command from LLM: %v", err)
-			handleErrorWithAssistance(logger, llmClient, cfg, err.Error())
+			reportLLMError(logger, "getting command from LLM", err)
+			os.Exit(1)
+		}
+
+		// Avoid suggesting programs that aren't installed
+		suggestions = avoidMissingTools(ctx, logger, llmClient, cfg, prompt, suggestions, system.PackageManagers)
+		helptext.Validate(suggestions, docs)
+
+		if *printFlag {
+			if err := printSuggestions(os.Stdout, suggestions, *jsonFlag); err != nil {
+				logger.Fatalf("Error printing suggestions: %v", err)
+			}
 			return
 		}
 
+		// Commands handle Ctrl-C themselves from here on
+		stop()
+
 		// Output command for user to edit and execute
-		shellHandler := shell.NewHandler(cfg.Shell, logger, llmClient, cfg, handleErrorWithAssistance)
-		if err := shellHandler.OutputCommand(command); err != nil {
+		shellHandler := shell.NewHandler(cfg.Shell, logger, llmClient, cfg, assist)
+		if err := shellHandler.OutputCommand(suggestions); err != nil {
 			logger.Printf("Error with command: %v", err)
-			handleErrorWithAssistance(logger, llmClient, cfg, err.Error())
+			assist(logger, llmClient, cfg, llm.Failure{Message: err.Error()})
 			return
 		}
 	}
 }
 
-func handleErrorWithAssistance(logger *log.Logger, llmClient llm.Client, cfg *config.Config, errResponse string) {
+// setup loads the configuration, describes the system and project to the LLM and
+// creates the redactor that removes secrets from everything sent to it
+func setup(logger *log.Logger, currentDir string, showRedactions bool) (*config.Config, *sysinfo.Info, *redact.Redactor) {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatalf("Error loading config: %v", err)
+	}
+
+	// Tell the LLM which system, package managers and project tools are in use
+	system := sysinfo.Detect()
+	cfg.OS = system.Describe(cfg.OS)
+	cfg.Project = project.Detect(currentDir).String()
+	cfg.Tools = tools.LoadInventory().String()
+
+	// Remove secrets from everything that is sent to the LLM
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		logger.Fatalf("Error creating redactor: %v", err)
+	}
+	if redactor != nil && showRedactions {
+		redactor.OnRedact = func(r redact.Redaction) {
+			logger.Printf("Redacted %s", r)
+		}
+	}
+	return cfg, system, redactor
+}
+
+// runChat starts an interactive chat, or lists the saved ones
+func runChat(args []string) {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	resumeFlag := flags.String("resume", "", "Continue the saved session with this name")
+	listFlag := flags.Bool("list", false, "List saved sessions and exit")
+	showRedactionsFlag := flags.Bool("show-redactions", false, "Report secrets that were removed before sending data to the LLM")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		log.Fatal("Usage: kass chat [-resume name] [-list]")
+	}
+
+	logger := log.New(os.Stderr, "[kass] ", log.LstdFlags)
+
+	if *listFlag {
+		sessions, err := chat.List()
+		if err != nil {
+			logger.Fatalf("Error listing sessions: %v", err)
+		}
+		for _, session := range sessions {
+			fmt.Println(session.Summary())
+		}
+		return
+	}
+
+	var session *chat.Session
+	if *resumeFlag != "" {
+		var err error
+		session, err = chat.Load(*resumeFlag)
+		if err != nil {
+			logger.Fatalf("Error: %v", err)
+		}
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		logger.Fatalf("Error getting current directory: %v", err)
+	}
+	cfg, _, redactor := setup(logger, currentDir, *showRedactionsFlag)
+
+	llmClient, err := llm.NewClient(cfg)
+	if err != nil {
+		logger.Fatalf("Error creating LLM client: %v", err)
+	}
+
+	if err := chat.New(logger, llmClient, cfg, redactor, currentDir, session).Run(context.Background()); err != nil {
+		logger.Fatalf("Error: %v", err)
+	}
+}
+
+// interruptible returns a context that is cancelled by Ctrl-C or SIGTERM, so waiting
+// for the LLM can be stopped without killing kass
+func interruptible() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// reportLLMError logs a failed LLM request along with what the user can do about it.
+// Asking the same LLM for help with its own failure wouldn't work, so no assistance is offered.
+func reportLLMError(logger *log.Logger, action string, err error) {
+	logger.Printf("Error %s: %v", action, err)
+	if hint := llm.Hint(err); hint != "" {
+		logger.Print(hint)
+	}
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// fileSection turns file contents into context parts. When the budget is tight,
+// the least relevant files are dropped first if ranked is set, and the largest otherwise.
+func fileSection(files []dirutil.File, omitted []dirutil.Omission, ranked bool) contextbuilder.Section {
+	section := contextbuilder.Section{Title: "\nFile contents:\n", Share: 4}
+	for i, file := range files {
+		priority := -len(file.Content)
+		if ranked {
+			priority = -i
+		}
+		section.Parts = append(section.Parts, contextbuilder.Part{
+			Name:     file.Path,
+			Text:     fmt.Sprintf("\nFile: %s\nContents:\n%s\n", file.Path, file.Content),
+			Priority: priority,
+		})
+	}
+	if len(omitted) > 0 {
+		section.Parts = append(section.Parts, contextbuilder.Part{
+			Name:     "list of omitted files",
+			Text:     dirutil.FormatOmissions(omitted),
+			Priority: 1,
+			Cut:      contextbuilder.KeepStart,
+		})
+	}
+	return section
+}
+
+// gitSection turns the repository state into context parts. The diffs are
+// shortened first when the budget is tight, the summary last.
+func gitSection(info *gitinfo.Info) contextbuilder.Section {
+	section := contextbuilder.Section{Share: 3}
+	section.Parts = append(section.Parts, contextbuilder.Part{
+		Name:     "git summary",
+		Text:     "\n" + info.Summary(),
+		Priority: 2,
+		Cut:      contextbuilder.KeepStart,
+	})
+	if info.StagedDiff != "" {
+		section.Parts = append(section.Parts, contextbuilder.Part{
+			Name:     "staged diff",
+			Text:     "\nStaged changes (git diff --cached):\n" + info.StagedDiff + "\n",
+			Priority: 1,
+			Cut:      contextbuilder.KeepStart,
+		})
+	}
+	if info.UnstagedDiff != "" {
+		section.Parts = append(section.Parts, contextbuilder.Part{
+			Name: "unstaged diff",
+			Text: "\nUnstaged changes (git diff):\n" + info.UnstagedDiff + "\n",
+			Cut:  contextbuilder.KeepStart,
+		})
+	}
+	return section
+}
+
+// helpSection turns the help texts of programs into context parts
+func helpSection(docs map[string]string) contextbuilder.Section {
+	names := make([]string, 0, len(docs))
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	section := contextbuilder.Section{Share: 2}
+	for _, name := range names {
+		section.Parts = append(section.Parts, contextbuilder.Part{
+			Name: "help text of " + name,
+			Text: fmt.Sprintf("\nUsage of %s:\n%s\n", name, helptext.Excerpt(docs[name])),
+			Cut:  contextbuilder.KeepStart,
+		})
+	}
+	return section
+}
+
+// avoidMissingTools asks the LLM once more for commands that only use installed programs
+// if any suggestion needs one that is missing. Programs that are still missing
+// afterwards are noted on the suggestions along with how to install them.
+func avoidMissingTools(ctx context.Context, logger *log.Logger, llmClient llm.Client, cfg *config.Config, prompt string, suggestions []llm.Suggestion, managers []string) []llm.Suggestion {
+	missing := tools.Check(suggestions, managers)
+	if len(missing) == 0 {
+		return suggestions
+	}
+
+	logger.Printf("Suggested commands use programs that aren't installed (%s), asking for alternatives", strings.Join(missing, ", "))
+	spinner := progress.Start("Waiting for " + cfg.LLM.Provider)
+	retry, err := llmClient.GetCommand(ctx, prompt+"\n\n"+tools.AlternativesPrompt(missing))
+	spinner.Stop()
+	if err != nil {
+		logger.Printf("Warning: Could not get alternatives: %v", err)
+		return suggestions
+	}
+	if len(tools.Check(retry, managers)) >= len(missing) {
+		return suggestions
+	}
+	return retry
+}
+
+// printSuggestions writes the suggested commands to w, one per line or as a JSON array
+func printSuggestions(w io.Writer, suggestions []llm.Suggestion, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(suggestions)
+	}
+
+	for _, suggestion := range suggestions {
+		if _, err := fmt.Fprintln(w, suggestion.Command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleErrorWithAssistance(ctx context.Context, logger *log.Logger, llmClient llm.Client, cfg *config.Config, redactor *redact.Redactor, failure llm.Failure) {
 	fmt.Printf("Would you like assistance with this error? [Y/n] ")
 	var willAssist string
 	fmt.Scanln(&willAssist)
@@ -115,17 +475,39 @@ func handleErrorWithAssistance(logger *log.Logger, llmClient llm.Client, cfg *co
 			dirInfo = "No directory information available"
 		}
 
-		shellHandler := shell.NewHandler(cfg.Shell, logger, llmClient, cfg, handleErrorWithAssistance)
+		shellHandler := shell.NewHandler(cfg.Shell, logger, llmClient, cfg, nil)
 		history, err := shellHandler.GetHistory(20)
 		if err != nil {
 			logger.Printf("Warning: Could not get shell history: %v", err)
 			history = "No shell history available"
 		}
 
-		// Add directory information to error context
-		response, err := llmClient.HandleError(errResponse, dirInfo+"\n"+history)
+		// Remove secrets from the failed command, its error output and the shell history
+		failure.Message = redactor.Redact("error message", failure.Message)
+		failure.Command = redactor.Redact("failed command", failure.Command)
+		failure.Stderr = redactor.Redact("command error output", failure.Stderr)
+		history = redactor.Redact("shell history", history)
+
+		// Add directory information to error context, keeping the most recent history if it doesn't all fit
+		builder := contextbuilder.New(cfg)
+		builder.Reserve(failure.String())
+		builder.Add(contextbuilder.Section{
+			Share: 1,
+			Parts: []contextbuilder.Part{{Name: "directory listing", Text: redactor.Redact("directory listing", dirInfo), Cut: contextbuilder.KeepStart}},
+		})
+		builder.Add(contextbuilder.Section{
+			Share: 1,
+			Parts: []contextbuilder.Part{{Name: "shell history", Text: "\n" + history, Cut: contextbuilder.KeepEnd}},
+		})
+		spinner := progress.Start("Waiting for " + cfg.LLM.Provider)
+		response, err := llmClient.HandleError(ctx, failure, builder.Build(""))
+		spinner.Stop()
 		if err != nil {
-			logger.Printf("Error getting assistance: %v", err)
+			if response != "" {
+				// What arrived before the reply was cut off may still help
+				fmt.Println(response)
+			}
+			reportLLMError(logger, "getting assistance", err)
 			return
 		}
 		fmt.Println(response)
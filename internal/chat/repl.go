@@ -0,0 +1,341 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/evesfect/k-assist/internal/config"
+	"github.com/evesfect/k-assist/internal/contextbuilder"
+	"github.com/evesfect/k-assist/internal/dirutil"
+	"github.com/evesfect/k-assist/internal/llm"
+	"github.com/evesfect/k-assist/internal/progress"
+	"github.com/evesfect/k-assist/internal/redact"
+	"github.com/evesfect/k-assist/internal/shell"
+)
+
+// maxOutputBytes bounds the output of a /run command shared with the LLM
+const maxOutputBytes = 16 * 1024
+
+const helpText = `Commands:
+  /context         Share the directory listing with your next message
+  /context <file>  Share a file with your next message
+  /run <command>   Run a command and share its output with your next message
+  /clear           Forget the conversation
+  /save [name]     Save the conversation, and keep saving it after every reply
+  /sessions        List saved conversations
+  /resume <name>   Continue a saved conversation
+  /help            Show this help
+  /exit            Leave the chat (or press Ctrl-D)`
+
+// REPL is an interactive chat with the LLM that keeps the conversation between messages
+type REPL struct {
+	logger    *log.Logger
+	llmClient llm.Client
+	config    *config.Config
+	redactor  *redact.Redactor
+	session   *Session
+	shell     *shell.Session // Started by the first /run
+	dir       string
+	pending   []string // Context shared with the next message
+}
+
+// New creates a chat in dir. session is a saved conversation to continue, or nil to start a new one.
+func New(logger *log.Logger, llmClient llm.Client, cfg *config.Config, redactor *redact.Redactor, dir string, session *Session) *REPL {
+	if session == nil {
+		session = &Session{Dir: dir, Created: time.Now()}
+	}
+	return &REPL{
+		logger:    logger,
+		llmClient: llmClient,
+		config:    cfg,
+		redactor:  redactor,
+		session:   session,
+		dir:       dir,
+	}
+}
+
+// Run reads messages and commands until the user leaves or ctx is done
+func (r *REPL) Run(ctx context.Context) error {
+	rl, err := readline.New("> ")
+	if err != nil {
+		return fmt.Errorf("error creating readline instance: %w", err)
+	}
+	defer rl.Close()
+	defer func() {
+		if r.shell != nil {
+			r.shell.Close()
+		}
+	}()
+
+	if r.session.Name != "" {
+		fmt.Printf("Resuming %q with %d messages. Type /help for commands.\n", r.session.Name, len(r.session.Messages))
+	} else {
+		fmt.Println("Type /help for commands.")
+	}
+
+	for ctx.Err() == nil {
+		rl.SetPrompt("> ")
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			if line == "" {
+				return nil
+			}
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading line: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "/"):
+			if done := r.command(rl, line); done {
+				return nil
+			}
+		default:
+			r.send(ctx, line)
+		}
+	}
+	return nil
+}
+
+// command runs a slash command and reports whether the user wants to leave
+func (r *REPL) command(rl *readline.Instance, line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		fmt.Println(helpText)
+	case "/clear":
+		r.session.Messages = nil
+		r.pending = nil
+		fmt.Println("Conversation cleared.")
+	case "/context":
+		r.shareContext(arg)
+	case "/run":
+		if arg == "" {
+			fmt.Println("Usage: /run <command>")
+			break
+		}
+		r.run(rl, arg)
+	case "/save":
+		if arg != "" {
+			r.session.Name = arg
+		}
+		if r.session.Name == "" {
+			fmt.Println("Usage: /save <name>")
+			break
+		}
+		if err := r.session.Save(); err != nil {
+			r.logger.Printf("Error saving session: %v", err)
+			break
+		}
+		fmt.Printf("Saved as %q, later replies are saved automatically.\n", r.session.Name)
+	case "/sessions":
+		sessions, err := List()
+		if err != nil {
+			r.logger.Printf("Error listing sessions: %v", err)
+			break
+		}
+		if len(sessions) == 0 {
+			fmt.Println("No saved sessions.")
+		}
+		for _, session := range sessions {
+			fmt.Println(session.Summary())
+		}
+	case "/resume":
+		if arg == "" {
+			fmt.Println("Usage: /resume <name>")
+			break
+		}
+		session, err := Load(arg)
+		if err != nil {
+			r.logger.Printf("Error: %v", err)
+			break
+		}
+		r.session = session
+		r.pending = nil
+		fmt.Printf("Resumed %q with %d messages.\n", session.Name, len(session.Messages))
+	default:
+		fmt.Printf("Unknown command %s, type /help for commands.\n", name)
+	}
+	return false
+}
+
+// shareContext adds the directory listing, or a file when path is given, to the next message
+func (r *REPL) shareContext(path string) {
+	if path == "" {
+		listing, err := dirutil.GetCurrentDirectoryContents(r.dir)
+		if err != nil {
+			r.logger.Printf("Error reading directory contents: %v", err)
+			return
+		}
+		r.pending = append(r.pending, r.redactor.Redact("directory listing", listing))
+		fmt.Println("The directory listing will be sent with your next message.")
+		return
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.dir, path)
+	}
+	content, err := dirutil.ReadFile(path, dirutil.ContentLimits{MaxFileBytes: r.config.Context.MaxFileBytes})
+	if err != nil {
+		r.logger.Printf("Error reading file: %v", err)
+		return
+	}
+	content = r.redactor.RedactFile(path, content)
+	r.pending = append(r.pending, fmt.Sprintf("File: %s\nContents:\n%s", path, content))
+	fmt.Printf("%s will be sent with your next message.\n", path)
+}
+
+// run executes a command in a shell that is kept for the whole chat, so cd and
+// exports carry over, and adds its output to the next message
+func (r *REPL) run(rl *readline.Instance, command string) {
+	if runtime.GOOS == "windows" {
+		fmt.Println("/run is not supported on Windows yet.")
+		return
+	}
+	if !shell.ConfirmIfDangerous(rl, r.logger, r.config.Safety, command) {
+		return
+	}
+	if r.shell == nil {
+		session, err := shell.NewSession(shell.ResolveShell(r.config.Shell), r.dir)
+		if err != nil {
+			r.logger.Printf("Error starting shell session: %v", err)
+			return
+		}
+		r.shell = session
+	}
+
+	// stdout and stderr are copied from separate goroutines, so the shared buffer is locked
+	workDir := r.dir
+	output := &lockedBuffer{}
+	result, err := r.shell.Run(command, output, output)
+	if err != nil {
+		r.logger.Printf("Error running command: %v", err)
+		r.shell.Close()
+		r.shell = nil
+		return
+	}
+	r.dir = result.Dir
+
+	text := tail(output.String(), maxOutputBytes)
+	text = r.redactor.Redact("command output", text)
+	command = r.redactor.Redact("command", command)
+	r.pending = append(r.pending, fmt.Sprintf("I ran `%s` in %s (exit status %d). Output:\n%s", command, workDir, result.ExitCode, text))
+	fmt.Println("The output will be sent with your next message.")
+}
+
+// send adds a message to the conversation and prints the reply as it is generated
+func (r *REPL) send(ctx context.Context, text string) {
+	content := r.redactor.Redact("chat message", text)
+	if len(r.pending) > 0 {
+		content += "\n\nContext:\n" + strings.Join(r.pending, "\n\n")
+	}
+	r.session.Messages = append(r.session.Messages, llm.Message{Role: llm.RoleUser, Content: content})
+
+	messages, dropped := r.fit(r.session.Messages)
+	if dropped > 0 {
+		r.logger.Printf("Leaving out the %d oldest messages to fit the model's context window", dropped)
+	}
+
+	// Ctrl-C cancels the reply, but not the chat
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	spinner := progress.Start("Waiting for " + r.config.LLM.Provider)
+	reply, err := r.llmClient.Chat(ctx, messages, spinner.Writer(os.Stdout))
+	spinner.Stop()
+	fmt.Println()
+	if errors.Is(err, llm.ErrTruncated) {
+		// The partial reply was shown, so it is kept like a complete one
+		r.logger.Printf("Warning: %v. %s", err, llm.Hint(err))
+		err = nil
+	}
+	if err != nil {
+		// Keep the message out of the history so it can be sent again
+		r.session.Messages = r.session.Messages[:len(r.session.Messages)-1]
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Cancelled.")
+			return
+		}
+		r.logger.Printf("Error getting response from LLM: %v", err)
+		if hint := llm.Hint(err); hint != "" {
+			r.logger.Print(hint)
+		}
+		return
+	}
+	r.pending = nil
+	r.session.Messages = append(r.session.Messages, llm.Message{Role: llm.RoleAssistant, Content: reply})
+
+	if r.session.Name != "" {
+		if err := r.session.Save(); err != nil {
+			r.logger.Printf("Warning: Could not save session: %v", err)
+		}
+	}
+}
+
+// fit drops the oldest exchanges until the conversation fits the model's context
+// window. The latest message is always kept. It returns how many messages were dropped.
+func (r *REPL) fit(messages []llm.Message) ([]llm.Message, int) {
+	builder := contextbuilder.New(r.config)
+	total := 0
+	for _, msg := range messages {
+		total += builder.Tokens(msg.Content)
+	}
+
+	dropped := 0
+	for total > builder.Budget() && len(messages) > 2 {
+		// Drop a question together with its answer, so the conversation still starts with the user
+		total -= builder.Tokens(messages[0].Content) + builder.Tokens(messages[1].Content)
+		messages = messages[2:]
+		dropped += 2
+	}
+	return messages, dropped
+}
+
+// lockedBuffer is a buffer that can be written from several goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// tail keeps the last limit bytes of command output
+func tail(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	return "[... earlier output omitted ...]\n" + text[len(text)-limit:]
+}
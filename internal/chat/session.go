@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/llm"
+)
+
+// validName keeps session names safe to use as file names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Session is a conversation that can be saved and resumed later
+type Session struct {
+	Name     string        `json:"name"`
+	Dir      string        `json:"dir"` // Working directory the session was started in
+	Created  time.Time     `json:"created"`
+	Updated  time.Time     `json:"updated"`
+	Messages []llm.Message `json:"messages"`
+}
+
+// SessionsDir returns the directory sessions are saved in
+func SessionsDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "kass", "sessions"), nil
+}
+
+func sessionPath(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	dir, err := SessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// Load reads a saved session
+func Load(name string) (*Session, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no session named %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("error parsing session %s: %w", path, err)
+	}
+	session.Name = name
+	return &session, nil
+}
+
+// Save writes the session under its name. Sessions can hold command output and
+// file contents, so they are only readable by the user.
+func (s *Session) Save() error {
+	path, err := sessionPath(s.Name)
+	if err != nil {
+		return err
+	}
+	s.Updated = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding session: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating sessions directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing session: %w", err)
+	}
+	return nil
+}
+
+// List returns the saved sessions, most recently updated first
+func List() ([]*Session, error) {
+	dir, err := SessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading sessions directory: %w", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		session, err := Load(name)
+		if err != nil {
+			continue // Skip files that aren't sessions
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+// Summary describes a session in one line for listings
+func (s *Session) Summary() string {
+	title := "(empty)"
+	for _, msg := range s.Messages {
+		if msg.Role == llm.RoleUser {
+			title = firstLine(msg.Content, 60)
+			break
+		}
+	}
+	return fmt.Sprintf("%-20s %s  %3d messages  %s", s.Name, s.Updated.Format("2006-01-02 15:04"), len(s.Messages), title)
+}
+
+// firstLine returns the first non-empty line of text, shortened to limit characters
+func firstLine(text string, limit int) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > limit {
+			return string(runes[:limit]) + "..."
+		}
+		return line
+	}
+	return ""
+}
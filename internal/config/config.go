@@ -5,31 +5,99 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"time"
 )
 
 type LLMConfig struct {
-	Provider string `json:"provider"` // "openai", "gemini", or "claude"
+	Provider string `json:"provider"` // "openai", "gemini", "claude", or "ollama"
 	APIKey   string `json:"api_key"`
 	Model    string `json:"model"`
+	BaseURL  string `json:"base_url,omitempty"` // Overrides the provider's default API endpoint
+
+	// Optional settings for OpenAI-compatible endpoints
+	Organization string            `json:"organization,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"` // Extra HTTP headers sent with every request
+
+	// How long Ollama keeps the model loaded after a request (e.g. "5m", "-1")
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+// SafetyRule is a user-defined rule that flags commands matching a regular expression
+type SafetyRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Message string `json:"message,omitempty"`
+}
+
+type SafetyConfig struct {
+	DisabledRules []string     `json:"disabled_rules,omitempty"` // Names of built-in rules to turn off
+	Rules         []SafetyRule `json:"rules,omitempty"`
+}
+
+// RedactionPattern is a user-defined regular expression for secrets to remove
+type RedactionPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// RedactionConfig controls how secrets are removed before anything is sent to the LLM
+type RedactionConfig struct {
+	Disabled bool               `json:"disabled,omitempty"`
+	Patterns []RedactionPattern `json:"patterns,omitempty"`
+}
+
+// ContextConfig limits how much directory data is sent to the LLM
+type ContextConfig struct {
+	MaxFileBytes  int `json:"max_file_bytes,omitempty"`  // Larger files are truncated with -A and -r
+	MaxTotalBytes int `json:"max_total_bytes,omitempty"` // Total file data included with -A and -r
+	MaxFiles      int `json:"max_files,omitempty"`       // Files picked by relevance with -r
+
+	// Size of the model's context window in tokens, detected from the model name if unset
+	WindowTokens int `json:"window_tokens,omitempty"`
+}
+
+// TimeoutConfig bounds how long each mode waits for the LLM, as durations like "30s" or "5m".
+// "0" waits until the request finishes or is cancelled with Ctrl-C.
+type TimeoutConfig struct {
+	Command  string `json:"command,omitempty"`  // Command suggestions
+	Response string `json:"response,omitempty"` // Streamed answers with -c
+	Error    string `json:"error,omitempty"`    // Error assistance
+	Chat     string `json:"chat,omitempty"`     // Each reply in kass chat
 }
 
 type Config struct {
-	OS        string    `json:"os"`
-	User      string    `json:"user"`
-	LLM       LLMConfig `json:"llm"`
-	MaxTokens int       `json:"max_tokens"`
-	Shell     string    `json:"shell"`
+	OS        string          `json:"os,omitempty"` // Overrides the detected operating system
+	User      string          `json:"user"`
+	LLM       LLMConfig       `json:"llm"`
+	MaxTokens int             `json:"max_tokens"`
+	Shell     string          `json:"shell"`
+	Safety    SafetyConfig    `json:"safety,omitempty"`
+	Context   ContextConfig   `json:"context,omitempty"`
+	Redaction RedactionConfig `json:"redaction,omitempty"`
+	Timeouts  TimeoutConfig   `json:"timeouts,omitempty"`
+
+	// Facts about the project in the working directory and the installed tools, detected at runtime
+	Project string `json:"-"`
+	Tools   string `json:"-"`
 }
 
 // Default configuration values
 const (
-	DefaultMaxTokens = 50
+	DefaultMaxTokens = 500
 	ConfigFileName   = "config.json"
 
 	// Default models for each provider
 	DefaultOpenAIModel = "gpt-3.5-turbo"
 	DefaultGeminiModel = "gemini-pro"
 	DefaultClaudeModel = "claude-3-sonnet-20240229"
+	DefaultOllamaModel = "llama3.1"
+
+	// Default timeouts for each mode
+	DefaultCommandTimeout  = "30s"
+	DefaultResponseTimeout = "5m"
+	DefaultErrorTimeout    = "1m"
+	DefaultChatTimeout     = "5m"
 )
 
 // Load reads and parses the configuration file
@@ -77,16 +145,60 @@ func validateAndSetDefaults(config *Config) error {
 			config.LLM.Model = DefaultGeminiModel
 		case "claude":
 			config.LLM.Model = DefaultClaudeModel
+		case "ollama":
+			config.LLM.Model = DefaultOllamaModel
 		default:
 			return fmt.Errorf("unsupported LLM provider: %s", config.LLM.Provider)
 		}
 	}
 
+	// Set timeout defaults and check that they parse
+	for _, timeout := range []struct {
+		name  string
+		value *string
+		def   string
+	}{
+		{"command", &config.Timeouts.Command, DefaultCommandTimeout},
+		{"response", &config.Timeouts.Response, DefaultResponseTimeout},
+		{"error", &config.Timeouts.Error, DefaultErrorTimeout},
+		{"chat", &config.Timeouts.Chat, DefaultChatTimeout},
+	} {
+		if *timeout.value == "" {
+			*timeout.value = timeout.def
+		}
+		if d, err := time.ParseDuration(*timeout.value); err != nil || d < 0 {
+			return fmt.Errorf("invalid timeouts.%s %q, use a duration like \"30s\" or \"5m\"", timeout.name, *timeout.value)
+		}
+	}
+
+	// Validate user-defined safety rules
+	for _, rule := range config.Safety.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("safety rule with pattern %q must have a name", rule.Pattern)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern for safety rule %q: %w", rule.Name, err)
+		}
+	}
+
+	// Validate user-defined redaction patterns
+	for _, pattern := range config.Redaction.Patterns {
+		if pattern.Name == "" {
+			return fmt.Errorf("redaction pattern %q must have a name", pattern.Pattern)
+		}
+		if _, err := regexp.Compile(pattern.Pattern); err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern.Name, err)
+		}
+	}
+
 	// Check for API key in environment variables if not in config
 	if config.LLM.APIKey == "" {
 		envVar := fmt.Sprintf("KASS_%s_API_KEY", config.LLM.Provider)
 		config.LLM.APIKey = os.Getenv(envVar)
-		if config.LLM.APIKey == "" {
+		// Ollama and self-hosted OpenAI-compatible servers usually don't require a key
+		keyless := config.LLM.Provider == "ollama" ||
+			(config.LLM.Provider == "openai" && config.LLM.BaseURL != "")
+		if config.LLM.APIKey == "" && !keyless {
 			return fmt.Errorf("API key not found in config or environment variable %s", envVar)
 		}
 	}
@@ -119,7 +231,6 @@ func ensureConfigFile() (string, error) {
 
 		// Create default config file
 		defaultConfig := Config{
-			OS:        "linux", // This should be detected
 			User:      os.Getenv("USER"),
 			MaxTokens: DefaultMaxTokens,
 			LLM: LLMConfig{
@@ -0,0 +1,253 @@
+package contextbuilder
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+const (
+	// systemPromptReserve leaves room for the system prompt the llm package adds
+	systemPromptReserve = 1000
+
+	// safetyMargin is the fraction of the budget kept free because token counts are estimates
+	safetyMargin = 0.1
+
+	// minBudget is used when the window is too small for the reserves, so some context is still sent
+	minBudget = 512
+
+	// minCutTokens is the smallest space worth filling with a shortened part
+	minCutTokens = 64
+)
+
+// CutMode decides how a part is shortened when it doesn't fit
+type CutMode int
+
+const (
+	NoCut     CutMode = iota // Drop the part entirely
+	KeepStart                // Keep the first lines, e.g. of a directory listing
+	KeepEnd                  // Keep the last lines, e.g. of the shell history
+)
+
+// Part is a piece of context, like a file or the shell history, that competes for space in the prompt
+type Part struct {
+	Name     string // Listed when the part is left out, e.g. a file path
+	Text     string
+	Priority int // Parts with lower priority are dropped first
+	Cut      CutMode
+}
+
+// Section groups parts of one kind under a share of the budget. Space a section
+// doesn't need is handed to the others.
+type Section struct {
+	Title string  // Written before the parts, may be empty
+	Share float64 // Relative share of the budget
+	Parts []Part
+}
+
+// Builder assembles context for a prompt so that it fits the model's context window
+type Builder struct {
+	estimator Estimator
+	budget    int
+	sections  []Section
+}
+
+// New creates a builder for the configured model. The budget is the model's context
+// window minus room for the system prompt and the reply.
+func New(cfg *config.Config) *Builder {
+	window := cfg.Context.WindowTokens
+	if window <= 0 {
+		window = ContextWindow(cfg.LLM.Provider, cfg.LLM.Model)
+	}
+
+	budget := int(float64(window-cfg.MaxTokens-systemPromptReserve) * (1 - safetyMargin))
+	if budget < minBudget {
+		budget = minBudget
+	}
+
+	return &Builder{
+		estimator: NewEstimator(cfg.LLM.Provider),
+		budget:    budget,
+	}
+}
+
+// Add adds a section of context. Sections are written in the order they are added.
+func (b *Builder) Add(section Section) {
+	b.sections = append(b.sections, section)
+}
+
+// Reserve takes room for text that is sent along with the context but not built by b
+func (b *Builder) Reserve(text string) {
+	b.budget -= b.estimator.Tokens(text)
+}
+
+// Budget returns the tokens left for context and prompt
+func (b *Builder) Budget() int {
+	return b.budget
+}
+
+// Tokens estimates how many tokens text takes for the configured model
+func (b *Builder) Tokens(text string) int {
+	return b.estimator.Tokens(text)
+}
+
+// Build returns the context followed by prompt, dropping or shortening the
+// lowest-priority parts of each section until everything fits the budget.
+// Parts that were left out are listed so the LLM knows about them.
+func (b *Builder) Build(prompt string) string {
+	available := b.budget - b.estimator.Tokens(prompt)
+	if available < 0 {
+		prompt = b.cut(prompt, max(b.budget, 0), KeepStart)
+		available = 0
+	}
+
+	var sb strings.Builder
+	var dropped []string
+	for i, allowed := range b.allocate(available) {
+		text, left := b.fill(b.sections[i], allowed)
+		sb.WriteString(text)
+		dropped = append(dropped, left...)
+	}
+
+	if len(dropped) > 0 {
+		sb.WriteString("\nLeft out to fit the model's context window:\n")
+		for _, name := range dropped {
+			sb.WriteString("- " + name + "\n")
+		}
+	}
+
+	if prompt != "" {
+		sb.WriteString("\n" + prompt)
+	}
+	return sb.String()
+}
+
+// allocate splits the available tokens between the sections by their share.
+// Sections that need less than their share get what they need, and the rest is
+// shared among the others.
+func (b *Builder) allocate(available int) []int {
+	need := make([]int, len(b.sections))
+	for i, section := range b.sections {
+		need[i] = b.estimator.Tokens(section.Title)
+		for _, part := range section.Parts {
+			need[i] += b.estimator.Tokens(part.Text)
+		}
+	}
+
+	allowed := make([]int, len(b.sections))
+	open := make([]int, 0, len(b.sections))
+	for i := range b.sections {
+		open = append(open, i)
+	}
+
+	for len(open) > 0 {
+		var totalShare float64
+		for _, i := range open {
+			totalShare += b.share(i)
+		}
+
+		// Satisfy every section that fits in its share, then split what is left again
+		var satisfied, still []int
+		for _, i := range open {
+			if need[i] <= int(float64(available)*b.share(i)/totalShare) {
+				satisfied = append(satisfied, i)
+			} else {
+				still = append(still, i)
+			}
+		}
+		if len(satisfied) == 0 {
+			for _, i := range open {
+				allowed[i] = int(float64(available) * b.share(i) / totalShare)
+			}
+			break
+		}
+		for _, i := range satisfied {
+			allowed[i] = need[i]
+			available -= need[i]
+		}
+		open = still
+	}
+	return allowed
+}
+
+func (b *Builder) share(i int) float64 {
+	if b.sections[i].Share <= 0 {
+		return 1
+	}
+	return b.sections[i].Share
+}
+
+// fill writes as many parts of a section as fit in allowed tokens, highest priority
+// first, and returns the names of the parts that were dropped or shortened
+func (b *Builder) fill(section Section, allowed int) (string, []string) {
+	order := make([]int, len(section.Parts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		return section.Parts[order[x]].Priority > section.Parts[order[y]].Priority
+	})
+
+	texts := make([]string, len(section.Parts))
+	var left []string
+	used := b.estimator.Tokens(section.Title)
+	for _, i := range order {
+		part := section.Parts[i]
+		tokens := b.estimator.Tokens(part.Text)
+		switch {
+		case used+tokens <= allowed:
+			texts[i] = part.Text
+			used += tokens
+		case part.Cut != NoCut && allowed-used >= minCutTokens:
+			texts[i] = b.cut(part.Text, allowed-used, part.Cut)
+			used = allowed
+			left = append(left, part.Name+" (truncated)")
+		default:
+			left = append(left, part.Name)
+		}
+	}
+
+	kept := strings.Join(texts, "")
+	if kept == "" {
+		return "", left
+	}
+	return section.Title + kept, left
+}
+
+// cut shortens text to about the given number of tokens, on a line boundary where possible
+func (b *Builder) cut(text string, tokens int, mode CutMode) string {
+	const marker = "[... truncated ...]\n"
+
+	size := b.estimator.Bytes(tokens) - len(marker)
+	if size >= len(text) {
+		return text
+	}
+	if size <= 0 {
+		return marker
+	}
+
+	if mode == KeepEnd {
+		start := len(text) - size
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		kept := text[start:]
+		if i := strings.IndexByte(kept, '\n'); i >= 0 && i < len(kept)-1 {
+			kept = kept[i+1:]
+		}
+		return marker + kept
+	}
+
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	kept := text[:size]
+	if i := strings.LastIndexByte(kept, '\n'); i > 0 {
+		kept = kept[:i+1]
+	} else {
+		kept += "\n"
+	}
+	return kept + marker
+}
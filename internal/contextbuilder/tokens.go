@@ -0,0 +1,93 @@
+package contextbuilder
+
+import (
+	"math"
+	"strings"
+)
+
+// DefaultContextWindow is used for models whose context window isn't known
+const DefaultContextWindow = 8192
+
+// ollamaContextWindow is Ollama's default num_ctx, which applies unless the
+// model or config raises it
+const ollamaContextWindow = 4096
+
+// contextWindows lists the context window of known models by name prefix.
+// More specific prefixes must come first.
+var contextWindows = map[string][]struct {
+	prefix string
+	tokens int
+}{
+	"openai": {
+		{"gpt-5", 400000},
+		{"gpt-4.1", 1047576},
+		{"gpt-4o", 128000},
+		{"gpt-4-turbo", 128000},
+		{"gpt-4-1106", 128000},
+		{"gpt-4-0125", 128000},
+		{"gpt-4-32k", 32768},
+		{"gpt-4", 8192},
+		{"gpt-3.5-turbo-instruct", 4096},
+		{"gpt-3.5-turbo", 16385},
+		{"o1", 200000},
+		{"o3", 200000},
+		{"o4", 200000},
+	},
+	"gemini": {
+		{"gemini-pro", 32760},
+		{"gemini-1.0", 32760},
+		{"gemini-1.5-pro", 2097152},
+		{"gemini-", 1048576},
+	},
+	"claude": {
+		{"claude-", 200000},
+	},
+}
+
+// charsPerToken is a conservative estimate of how many bytes of text make up one token
+var charsPerToken = map[string]float64{
+	"openai": 4,
+	"gemini": 4,
+	"claude": 3.5,
+	"ollama": 3.5,
+}
+
+// ContextWindow returns the context window of a model in tokens
+func ContextWindow(provider string, model string) int {
+	if provider == "ollama" {
+		return ollamaContextWindow
+	}
+
+	model = strings.TrimPrefix(strings.ToLower(model), "models/")
+	for _, entry := range contextWindows[provider] {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.tokens
+		}
+	}
+	return DefaultContextWindow
+}
+
+// Estimator approximates how many tokens a provider's tokenizer produces for a text.
+// Tokenizers differ per model, so estimates err on the high side.
+type Estimator struct {
+	charsPerToken float64
+}
+
+// NewEstimator returns an estimator for a provider
+func NewEstimator(provider string) Estimator {
+	ratio, ok := charsPerToken[provider]
+	if !ok {
+		ratio = 3.5
+	}
+	return Estimator{charsPerToken: ratio}
+}
+
+// Tokens returns the estimated number of tokens in text
+func (e Estimator) Tokens(text string) int {
+	return int(math.Ceil(float64(len(text)) / e.charsPerToken))
+}
+
+// Bytes returns roughly how many bytes of text fit in the given number of tokens
+func (e Estimator) Bytes(tokens int) int {
+	return int(float64(tokens) * e.charsPerToken)
+}
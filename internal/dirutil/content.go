@@ -0,0 +1,126 @@
+package dirutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Default limits for file contents included with -A
+const (
+	DefaultMaxFileBytes  = 32 * 1024
+	DefaultMaxTotalBytes = 256 * 1024
+
+	// sniffSize is how much of a file is inspected to decide whether it is binary
+	sniffSize = 8000
+)
+
+// errBinary is returned by readFileLimited for files that aren't text
+var errBinary = errors.New("binary file")
+
+// ContentLimits bounds how much file data is read into the prompt
+type ContentLimits struct {
+	MaxFileBytes  int // Larger files are cut down to their head and tail
+	MaxTotalBytes int // Files beyond this total are omitted
+}
+
+// withDefaults fills in unset limits
+func (l ContentLimits) withDefaults() ContentLimits {
+	if l.MaxFileBytes <= 0 {
+		l.MaxFileBytes = DefaultMaxFileBytes
+	}
+	if l.MaxTotalBytes <= 0 {
+		l.MaxTotalBytes = DefaultMaxTotalBytes
+	}
+	return l
+}
+
+// Omission records a file that was left out of, or shortened in, the prompt
+type Omission struct {
+	Path   string
+	Reason string
+}
+
+// FormatOmissions summarizes the files that were skipped or truncated
+func FormatOmissions(omitted []Omission) string {
+	if len(omitted) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nOmitted or truncated files:\n")
+	for _, o := range omitted {
+		sb.WriteString(fmt.Sprintf("- %s (%s)\n", o.Path, o.Reason))
+	}
+	return sb.String()
+}
+
+// isBinary reports whether the start of a file looks like binary data
+func isBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	return !strings.HasPrefix(http.DetectContentType(head), "text/")
+}
+
+// ReadFile reads one text file within limits.MaxFileBytes, like the files read with -A.
+// Binary files are refused.
+func ReadFile(path string, limits ContentLimits) (string, error) {
+	content, _, err := readFileLimited(path, limits.withDefaults().MaxFileBytes)
+	if errors.Is(err, errBinary) {
+		return "", fmt.Errorf("%s is a binary file", path)
+	}
+	return content, err
+}
+
+// readFileLimited reads at most limit bytes of a text file. Files larger than limit
+// keep their head and tail with a marker in between. truncated reports whether
+// anything was left out.
+func readFileLimited(path string, limit int) (content string, truncated bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	size := stat.Size()
+
+	head := make([]byte, min(int64(sniffSize), size))
+	if _, err := io.ReadFull(file, head); err != nil {
+		return "", false, err
+	}
+	if len(head) > 0 && isBinary(head) {
+		return "", false, errBinary
+	}
+
+	if size <= int64(limit) {
+		rest, err := io.ReadAll(file)
+		if err != nil {
+			return "", false, err
+		}
+		return string(head) + string(rest), false, nil
+	}
+
+	// Keep two thirds from the start of the file and one third from the end
+	headSize := limit * 2 / 3
+	tailSize := limit - headSize
+
+	headData := make([]byte, headSize)
+	if _, err := file.ReadAt(headData, 0); err != nil {
+		return "", false, err
+	}
+	tailData := make([]byte, tailSize)
+	if _, err := file.ReadAt(tailData, size-int64(tailSize)); err != nil && err != io.EOF {
+		return "", false, err
+	}
+
+	omitted := size - int64(headSize) - int64(tailSize)
+	return fmt.Sprintf("%s\n[... %d bytes omitted ...]\n%s", headData, omitted, tailData), true, nil
+}
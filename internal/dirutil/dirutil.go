@@ -1,10 +1,14 @@
 package dirutil
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/evesfect/k-assist/internal/redact"
 )
 
 // GetCurrentDirectoryContents returns a string containing information about the current directory
@@ -27,17 +31,10 @@ func GetAllDirectoryContents(dir string) (string, error) {
 	var info strings.Builder
 	info.WriteString(fmt.Sprintf("Current directory: %s\nAll directory contents:\n", dir))
 
-	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return err
-		}
+	err := walk(dir, func(relPath string, entry fs.DirEntry) error {
 		info.WriteString(fmt.Sprintf("- %s\n", relPath))
 		return nil
-	})
+	}, nil)
 
 	if err != nil {
 		return "", err
@@ -46,40 +43,105 @@ func GetAllDirectoryContents(dir string) (string, error) {
 	return info.String(), nil
 }
 
-// GetAllDirectoryContentsWithData returns information about all files and their contents
-func GetAllDirectoryContentsWithData(dir string) (string, error) {
-	var info strings.Builder
-	info.WriteString(fmt.Sprintf("Current directory: %s\nAll directory contents with data:\n", dir))
+// File is a text file read for the prompt
+type File struct {
+	Path    string // Relative to the walked directory
+	Content string
+	Reason  string // Why RelevantFiles picked the file
+}
 
-	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// ReadFiles reads the contents of all text files under dir. Binary and unreadable files
+// are skipped, large files are truncated according to limits, and everything that was
+// left out or shortened is returned as omissions. Secrets are removed with redactor,
+// which may be nil.
+func ReadFiles(dir string, limits ContentLimits, redactor *redact.Redactor) ([]File, []Omission, error) {
+	limits = limits.withDefaults()
 
-		relPath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return err
+	var files []File
+	var omitted []Omission
+	remaining := limits.MaxTotalBytes
+
+	err := walk(dir, func(relPath string, entry fs.DirEntry) error {
+		if !entry.Type().IsRegular() {
+			return nil
 		}
 
-		// Skip if it's a directory
-		if f.IsDir() {
-			info.WriteString(fmt.Sprintf("Directory: %s\n", relPath))
+		if remaining <= 0 {
+			omitted = append(omitted, Omission{relPath, "total size limit reached"})
 			return nil
 		}
 
 		// Read file contents
-		data, err := os.ReadFile(path)
+		data, truncated, err := readFileLimited(filepath.Join(dir, relPath), min(limits.MaxFileBytes, remaining))
+		if errors.Is(err, errBinary) {
+			omitted = append(omitted, Omission{relPath, "binary"})
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("error reading file %s: %w", relPath, err)
+			omitted = append(omitted, Omission{relPath, fmt.Sprintf("unreadable: %v", err)})
+			return nil
 		}
+		if truncated {
+			omitted = append(omitted, Omission{relPath, "truncated"})
+		}
+		remaining -= len(data)
 
-		info.WriteString(fmt.Sprintf("\nFile: %s\nContents:\n%s\n", relPath, string(data)))
+		files = append(files, File{Path: relPath, Content: redactor.RedactFile(relPath, data)})
 		return nil
+	}, func(relPath string, err error) {
+		omitted = append(omitted, Omission{relPath, fmt.Sprintf("unreadable: %v", err)})
 	})
 
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
+	return files, omitted, nil
+}
 
-	return info.String(), nil
+// walk visits every file and directory under dir that isn't excluded by .gitignore,
+// .kassignore or git's exclude files. fn receives paths relative to dir. Paths that
+// can't be read are skipped and reported to onError, which may be nil.
+func walk(dir string, fn func(relPath string, entry fs.DirEntry) error, onError func(relPath string, err error)) error {
+	matcher := NewMatcher(dir)
+	prefix, err := filepath.Rel(matcher.root, dir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, walkErr error) error {
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if walkErr != nil {
+			if relPath == "." {
+				return walkErr
+			}
+			if onError != nil {
+				onError(relPath, walkErr)
+			}
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath == "." {
+			return fn(relPath, entry)
+		}
+
+		// Ignore rules are relative to the repository root, which may be above dir
+		matchPath := filepath.Join(prefix, relPath)
+		if matcher.Ignored(matchPath, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			matcher.loadDir(matchPath)
+		}
+
+		return fn(relPath, entry)
+	})
 }
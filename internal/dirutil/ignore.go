@@ -0,0 +1,252 @@
+package dirutil
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileNames are read from every directory, later files taking precedence
+var ignoreFileNames = []string{".gitignore", ".kassignore"}
+
+// ignorePattern is a single line of an ignore file
+type ignorePattern struct {
+	base    string // Directory of the ignore file, relative to the matcher root ("" for the root)
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides which paths are ignored, following .gitignore semantics.
+// Patterns come from the global git excludes file, .git/info/exclude, and the
+// .gitignore and .kassignore files of each directory, with later and deeper
+// patterns taking precedence.
+type Matcher struct {
+	root     string // Repository root, or the walked directory if it isn't in a repository
+	patterns []ignorePattern
+	loaded   map[string]bool
+}
+
+// NewMatcher creates a matcher for walking dir. If dir is inside a git repository,
+// the ignore files between the repository root and dir are loaded as well.
+func NewMatcher(dir string) *Matcher {
+	root := findRepoRoot(dir)
+	if root == "" {
+		root = dir
+	}
+
+	m := &Matcher{root: root, loaded: make(map[string]bool)}
+
+	if excludesFile := globalExcludesFile(); excludesFile != "" {
+		m.loadFile(excludesFile, "")
+	}
+	m.loadFile(filepath.Join(root, ".git", "info", "exclude"), "")
+
+	// Load ignore files of the directories above dir, down to dir itself
+	if rel, err := filepath.Rel(root, dir); err == nil {
+		current := ""
+		m.loadDir(current)
+		if rel != "." {
+			for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+				current = path.Join(current, part)
+				m.loadDir(current)
+			}
+		}
+	}
+
+	return m
+}
+
+// Ignored reports whether a path, relative to the matcher root, is ignored
+func (m *Matcher) Ignored(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	if path.Base(relPath) == ".git" {
+		return true
+	}
+
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		target := relPath
+		if p.base != "" {
+			if !strings.HasPrefix(relPath, p.base+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(relPath, p.base+"/")
+		}
+		if p.re.MatchString(target) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// loadDir reads the ignore files of a directory relative to the matcher root
+func (m *Matcher) loadDir(relDir string) {
+	relDir = filepath.ToSlash(relDir)
+	if relDir == "." {
+		relDir = ""
+	}
+	if m.loaded[relDir] {
+		return
+	}
+	m.loaded[relDir] = true
+
+	for _, name := range ignoreFileNames {
+		m.loadFile(filepath.Join(m.root, filepath.FromSlash(relDir), name), relDir)
+	}
+}
+
+func (m *Matcher) loadFile(filename string, base string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if p, ok := parseIgnorePattern(scanner.Text(), base); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// parseIgnorePattern converts a line of an ignore file into a pattern
+func parseIgnorePattern(line string, base string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	// Patterns with a slash are relative to the ignore file, others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignorePattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globToRegexp converts a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				atStart := i == 0 || glob[i-1] == '/'
+				rest := glob[i+2:]
+				switch {
+				case atStart && strings.HasPrefix(rest, "/"):
+					// "**/" matches zero or more directories
+					sb.WriteString("(?:.*/)?")
+					i += 2
+				case atStart && rest == "":
+					// Trailing "/**" matches everything inside
+					sb.WriteString(".*")
+					i++
+				default:
+					sb.WriteString("[^/]*")
+					i++
+				}
+				continue
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// findRepoRoot returns the closest directory at or above dir that contains .git
+func findRepoRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
+
+// globalExcludesFile returns the path of git's core.excludesFile, or its default location
+func globalExcludesFile() string {
+	if out, err := exec.Command("git", "config", "--get", "core.excludesFile").Output(); err == nil {
+		excludesFile := strings.TrimSpace(string(out))
+		if strings.HasPrefix(excludesFile, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				excludesFile = filepath.Join(home, excludesFile[2:])
+			}
+		}
+		if excludesFile != "" {
+			return excludesFile
+		}
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
@@ -0,0 +1,358 @@
+package dirutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/evesfect/k-assist/internal/redact"
+)
+
+// DefaultMaxRelevantFiles is how many files RelevantFiles picks unless configured
+const DefaultMaxRelevantFiles = 8
+
+// maxScanBytes bounds how much file data is searched for prompt keywords
+const maxScanBytes = 8 * 1024 * 1024
+
+// Scores for the signals that make a file relevant
+const (
+	scoreMentioned   = 10 // The prompt names the file
+	scoreNameMatch   = 3  // A prompt keyword is part of the file name
+	scoreDirMatch    = 1  // A prompt keyword is part of a directory name
+	scoreContent     = 1  // A prompt keyword appears in the file, per keyword
+	scoreUncommitted = 2  // The file has uncommitted changes
+	scoreRecent      = 1  // The file was changed in one of the last commits
+)
+
+// recentCommits is how many commits are checked for recently changed files
+const recentCommits = 10
+
+// stopWords are left out of prompt keywords because they match almost anything
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "this": true, "that": true,
+	"what": true, "why": true, "how": true, "does": true, "from": true, "into": true,
+	"all": true, "file": true, "files": true, "code": true, "make": true, "use": true,
+	"add": true, "fix": true, "get": true, "set": true, "can": true, "you": true,
+	"please": true, "show": true, "find": true, "list": true, "run": true, "not": true,
+	"are": true, "was": true, "its": true, "should": true, "when": true, "where": true,
+	"which": true, "there": true, "here": true, "have": true, "need": true, "want": true,
+	"about": true, "them": true, "then": true, "than": true, "also": true, "only": true,
+	"write": true, "change": true, "explain": true, "work": true, "works": true,
+}
+
+// Selection controls which files RelevantFiles picks
+type Selection struct {
+	Prompt   string
+	Include  []string // Globs of files that are always included
+	Exclude  []string // Globs of files that are never included
+	MaxFiles int      // Most files picked by relevance, in addition to included ones
+}
+
+// candidate is a file being scored for relevance
+type candidate struct {
+	path      string
+	score     int
+	reasons   []string
+	forced    bool
+	content   string // Cached if the file was read while scoring
+	truncated bool
+	read      bool
+}
+
+// RelevantFiles picks the files under dir that are most relevant to the prompt and
+// reads their contents. Files score higher when the prompt mentions them, when prompt
+// keywords appear in their path or contents, and when they were changed recently in
+// git. Files are returned most relevant first, with File.Reason explaining the choice.
+func RelevantFiles(dir string, sel Selection, limits ContentLimits, redactor *redact.Redactor) ([]File, []Omission, error) {
+	limits = limits.withDefaults()
+	if sel.MaxFiles <= 0 {
+		sel.MaxFiles = DefaultMaxRelevantFiles
+	}
+
+	include, err := compileGlobs(sel.Include)
+	if err != nil {
+		return nil, nil, err
+	}
+	exclude, err := compileGlobs(sel.Exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keywords := promptKeywords(sel.Prompt)
+	mentioned := promptReferences(sel.Prompt)
+	uncommitted, recent := gitChangedFiles(dir)
+
+	var candidates []*candidate
+	scanned := 0
+	err = walk(dir, func(relPath string, entry fs.DirEntry) error {
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		slashPath := filepath.ToSlash(relPath)
+		if matchesAny(exclude, slashPath) {
+			return nil
+		}
+
+		c := &candidate{path: relPath, forced: matchesAny(include, slashPath)}
+		if c.forced {
+			c.reasons = append(c.reasons, "--include")
+		}
+		if isMentioned(slashPath, mentioned) {
+			c.add(scoreMentioned, "mentioned in prompt")
+		}
+		c.scoreName(slashPath, keywords)
+		if uncommitted[slashPath] {
+			c.add(scoreUncommitted, "uncommitted changes")
+		} else if recent[slashPath] {
+			c.add(scoreRecent, "recently committed")
+		}
+
+		// Search the contents for keywords while the scan budget lasts
+		if len(keywords) > 0 && scanned < maxScanBytes {
+			content, truncated, err := readFileLimited(filepath.Join(dir, relPath), limits.MaxFileBytes)
+			if err == nil {
+				scanned += len(content)
+				c.content, c.truncated, c.read = content, truncated, true
+				c.scoreContent(content, keywords)
+			} else if errors.Is(err, errBinary) && !c.forced {
+				return nil
+			}
+		}
+
+		if c.score > 0 || c.forced {
+			candidates = append(candidates, c)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].forced != candidates[j].forced {
+			return candidates[i].forced
+		}
+		return candidates[i].score > candidates[j].score
+	})
+
+	var files []File
+	var omitted []Omission
+	remaining := limits.MaxTotalBytes
+	picked := 0
+	for _, c := range candidates {
+		if !c.forced {
+			if picked >= sel.MaxFiles {
+				break
+			}
+			picked++
+		}
+
+		if remaining <= 0 {
+			omitted = append(omitted, Omission{c.path, "total size limit reached"})
+			continue
+		}
+
+		content, truncated := c.content, c.truncated
+		if !c.read || len(content) > remaining {
+			content, truncated, err = readFileLimited(filepath.Join(dir, c.path), min(limits.MaxFileBytes, remaining))
+			if errors.Is(err, errBinary) {
+				omitted = append(omitted, Omission{c.path, "binary"})
+				continue
+			}
+			if err != nil {
+				omitted = append(omitted, Omission{c.path, fmt.Sprintf("unreadable: %v", err)})
+				continue
+			}
+		}
+		if truncated {
+			omitted = append(omitted, Omission{c.path, "truncated"})
+		}
+		remaining -= len(content)
+
+		files = append(files, File{
+			Path:    c.path,
+			Content: redactor.RedactFile(c.path, content),
+			Reason:  strings.Join(c.reasons, ", "),
+		})
+	}
+	return files, omitted, nil
+}
+
+func (c *candidate) add(score int, reason string) {
+	c.score += score
+	c.reasons = append(c.reasons, reason)
+}
+
+// scoreName rewards keywords that appear in the file name or its directories
+func (c *candidate) scoreName(slashPath string, keywords []string) {
+	dirWords := pathWords(path.Dir(slashPath))
+	nameWords := pathWords(path.Base(slashPath))
+	for _, keyword := range keywords {
+		switch {
+		case matchesWord(keyword, nameWords):
+			c.add(scoreNameMatch, fmt.Sprintf("name matches %q", keyword))
+		case matchesWord(keyword, dirWords):
+			c.add(scoreDirMatch, fmt.Sprintf("directory matches %q", keyword))
+		}
+	}
+}
+
+// scoreContent rewards every keyword that appears in the file
+func (c *candidate) scoreContent(content string, keywords []string) {
+	content = strings.ToLower(content)
+	var found []string
+	for _, keyword := range keywords {
+		if len(keyword) >= 4 && strings.Contains(content, keyword) {
+			found = append(found, fmt.Sprintf("%q", keyword))
+		}
+	}
+	if len(found) > 0 {
+		c.score += scoreContent * len(found)
+		c.reasons = append(c.reasons, "contains "+strings.Join(found, ", "))
+	}
+}
+
+// promptKeywords returns the distinct lowercase words of a prompt that are worth matching
+func promptKeywords(prompt string) []string {
+	seen := make(map[string]bool)
+	var keywords []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(word) < 3 || stopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+	}
+	return keywords
+}
+
+// promptReferences returns the words of a prompt that look like file names or paths
+func promptReferences(prompt string) []string {
+	var refs []string
+	for _, field := range strings.Fields(prompt) {
+		field = strings.TrimLeft(field, "\"'`([{<")
+		field = strings.TrimRight(field, "\"'`,.:;!?)]}>")
+		field = strings.TrimPrefix(field, "./")
+		if field == "" || !strings.ContainsAny(field, "./") {
+			continue
+		}
+		refs = append(refs, filepath.ToSlash(field))
+	}
+	return refs
+}
+
+// isMentioned reports whether a path matches one of the prompt's file references
+func isMentioned(slashPath string, refs []string) bool {
+	for _, ref := range refs {
+		if slashPath == ref || strings.HasSuffix(slashPath, "/"+ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathWords splits a path into lowercase words at separators and camelCase boundaries
+func pathWords(p string) []string {
+	var words []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	var prev rune
+	for _, r := range p {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			flush()
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+		prev = r
+	}
+	flush()
+	return words
+}
+
+// matchesWord reports whether keyword equals one of words, allowing for plurals
+// and other suffixes on longer words (config, configs, configuration)
+func matchesWord(keyword string, words []string) bool {
+	for _, word := range words {
+		if word == keyword {
+			return true
+		}
+		if len(word) >= 4 && len(keyword) >= 4 &&
+			(strings.HasPrefix(word, keyword) || strings.HasPrefix(keyword, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGlobs converts --include and --exclude globs into gitignore-style patterns
+func compileGlobs(globs []string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for _, glob := range globs {
+		p, ok := parseIgnorePattern(glob, "")
+		if !ok {
+			return nil, fmt.Errorf("invalid glob %q", glob)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// matchesAny reports whether a file matches one of the patterns. As in gitignore,
+// a pattern that matches a directory matches every file beneath it, so "vendor",
+// "vendor/" and "internal/llm" select whole directories.
+func matchesAny(patterns []ignorePattern, slashPath string) bool {
+	for _, p := range patterns {
+		if !p.dirOnly && p.re.MatchString(slashPath) {
+			return true
+		}
+		for dir := path.Dir(slashPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if p.re.MatchString(dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gitChangedFiles returns the files under dir with uncommitted changes and the files
+// changed in recent commits, relative to dir. Both are empty outside a git repository.
+func gitChangedFiles(dir string) (uncommitted map[string]bool, recent map[string]bool) {
+	uncommitted = make(map[string]bool)
+	recent = make(map[string]bool)
+
+	gitLines := func(args ...string) []string {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		if err != nil {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(out)), "\n")
+	}
+
+	for _, file := range gitLines("diff", "--name-only", "--relative", "HEAD") {
+		uncommitted[file] = file != ""
+	}
+	for _, file := range gitLines("ls-files", "--others", "--exclude-standard") {
+		uncommitted[file] = file != ""
+	}
+	for _, file := range gitLines("log", "-n", fmt.Sprint(recentCommits), "--name-only", "--relative", "--pretty=format:") {
+		recent[file] = file != ""
+	}
+	return uncommitted, recent
+}
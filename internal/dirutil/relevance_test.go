@@ -0,0 +1,43 @@
+package dirutil
+
+import "testing"
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		// Bare names match files and directories at any depth
+		{"vendor", "vendor/x/y.go", true},
+		{"vendor", "src/vendor/y.go", true},
+		{"vendor", "vendors/y.go", false},
+		{"main.go", "cmd/kass/main.go", true},
+
+		// A trailing slash only matches directories
+		{"vendor/", "vendor/x/y.go", true},
+		{"vendor/", "vendor", false},
+		{"main.go/", "cmd/kass/main.go", false},
+
+		// Patterns with a slash are anchored to the root
+		{"internal/llm", "internal/llm/llm.go", true},
+		{"internal/llm", "x/internal/llm/llm.go", false},
+
+		// ** matches any number of directories
+		{"internal/**", "internal/llm/llm.go", true},
+		{"**/testdata", "internal/dirutil/testdata/a.txt", true},
+		{"**/*_test.go", "internal/dirutil/relevance_test.go", true},
+		{"*.md", "docs/guide/README.md", true},
+		{"*.md", "docs/guide.mdx", false},
+	}
+
+	for _, tt := range tests {
+		patterns, err := compileGlobs([]string{tt.glob})
+		if err != nil {
+			t.Fatalf("compileGlobs(%q): %v", tt.glob, err)
+		}
+		if got := matchesAny(patterns, tt.path); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
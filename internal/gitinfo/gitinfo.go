@@ -0,0 +1,212 @@
+package gitinfo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// recentCommits is how many commits are included in the log
+	recentCommits = 10
+
+	// maxDiffBytes bounds each diff before it is budgeted with the rest of the context
+	maxDiffBytes = 64 * 1024
+)
+
+// ErrNotRepository is returned when the directory isn't inside a git work tree
+var ErrNotRepository = errors.New("not a git repository")
+
+// Info describes the state of a git repository
+type Info struct {
+	Branch    string // Empty when HEAD is detached
+	Head      string // Abbreviated commit, empty before the first commit
+	Upstream  string
+	Ahead     int
+	Behind    int
+	Operation string // Rebase, merge, cherry-pick, revert or bisect in progress, if any
+
+	Status       string // git status --porcelain
+	StagedDiff   string
+	UnstagedDiff string
+	Log          string // Recent commits, one per line
+}
+
+// Collect gathers the state of the git repository containing dir
+func Collect(dir string) (*Info, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is not installed: %w", err)
+	}
+
+	gitDir, err := git(dir, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return nil, ErrNotRepository
+	}
+
+	info := &Info{}
+	branchStatus, err := git(dir, "status", "--porcelain=v2", "--branch", "--untracked-files=no")
+	if err != nil {
+		return nil, err
+	}
+	info.parseBranch(branchStatus)
+
+	if info.Status, err = git(dir, "status", "--porcelain"); err != nil {
+		return nil, err
+	}
+	info.Operation = operation(gitDir)
+
+	// These fail in a repository without commits, which is fine. External diff tools
+	// and colors from the user's git config would put something other than a plain
+	// patch in the prompt, so they are turned off.
+	info.StagedDiff, _ = git(dir, "diff", "--cached", "--no-ext-diff", "--no-color")
+	info.UnstagedDiff, _ = git(dir, "diff", "--no-ext-diff", "--no-color")
+	info.Log, _ = git(dir, "log", "-n", strconv.Itoa(recentCommits), "--oneline", "--decorate", "--no-color")
+
+	info.StagedDiff = limit(info.StagedDiff)
+	info.UnstagedDiff = limit(info.UnstagedDiff)
+	return info, nil
+}
+
+// Summary describes the branch, work tree status, operation in progress and recent commits
+func (i *Info) Summary() string {
+	var sb strings.Builder
+	sb.WriteString("Git repository:\n")
+
+	switch {
+	case i.Branch != "":
+		sb.WriteString("Branch: " + i.Branch)
+	case i.Head != "":
+		sb.WriteString("HEAD detached at " + i.Head)
+	default:
+		sb.WriteString("No commits yet")
+	}
+	if i.Upstream != "" {
+		sb.WriteString(fmt.Sprintf(" (tracking %s, ahead %d, behind %d)", i.Upstream, i.Ahead, i.Behind))
+	}
+	sb.WriteString("\n")
+
+	if i.Operation != "" {
+		sb.WriteString("In progress: " + i.Operation + "\n")
+	}
+
+	if i.Status == "" {
+		sb.WriteString("Working tree clean\n")
+	} else {
+		sb.WriteString("Status (git status --porcelain):\n" + i.Status + "\n")
+	}
+
+	if i.Log != "" {
+		sb.WriteString("Recent commits:\n" + i.Log + "\n")
+	}
+	return sb.String()
+}
+
+// parseBranch reads the branch headers of git status --porcelain=v2 --branch
+func (i *Info) parseBranch(status string) {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "#" {
+			continue
+		}
+		switch fields[1] {
+		case "branch.oid":
+			if fields[2] != "(initial)" && len(fields[2]) >= 7 {
+				i.Head = fields[2][:7]
+			}
+		case "branch.head":
+			if fields[2] != "(detached)" {
+				i.Branch = fields[2]
+			}
+		case "branch.upstream":
+			i.Upstream = fields[2]
+		case "branch.ab":
+			if len(fields) == 4 {
+				i.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+				i.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+			}
+		}
+	}
+}
+
+// operation describes a rebase, merge, cherry-pick, revert or bisect in progress
+func operation(gitDir string) string {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if !exists(dir) {
+			continue
+		}
+		if dir == "rebase-apply" && exists(filepath.Join(dir, "applying")) {
+			return "git am"
+		}
+
+		desc := "rebase"
+		if branch := readTrimmed(gitDir, dir, "head-name"); branch != "" {
+			desc += " of " + strings.TrimPrefix(branch, "refs/heads/")
+		}
+		if onto := readTrimmed(gitDir, dir, "onto"); len(onto) >= 7 {
+			desc += " onto " + onto[:7]
+		}
+		step, total := readTrimmed(gitDir, dir, "msgnum"), readTrimmed(gitDir, dir, "end")
+		if dir == "rebase-apply" {
+			step, total = readTrimmed(gitDir, dir, "next"), readTrimmed(gitDir, dir, "last")
+		}
+		if step != "" && total != "" {
+			desc += fmt.Sprintf(" (step %s of %s)", step, total)
+		}
+		return desc
+	}
+
+	switch {
+	case exists("MERGE_HEAD"):
+		return "merge"
+	case exists("CHERRY_PICK_HEAD"):
+		return "cherry-pick"
+	case exists("REVERT_HEAD"):
+		return "revert"
+	case exists("BISECT_LOG"):
+		return "bisect"
+	}
+	return ""
+}
+
+func readTrimmed(elem ...string) string {
+	data, err := os.ReadFile(filepath.Join(elem...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// limit cuts a diff that is too large to be useful, keeping its start
+func limit(diff string) string {
+	if len(diff) <= maxDiffBytes {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:maxDiffBytes], '\n')
+	if cut < 0 {
+		cut = maxDiffBytes
+	}
+	return diff[:cut] + fmt.Sprintf("\n[... %d bytes of diff omitted ...]", len(diff)-cut)
+}
+
+// git runs a git command in dir and returns its output without the trailing newline
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
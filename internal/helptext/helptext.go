@@ -0,0 +1,218 @@
+package helptext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/llm"
+	"github.com/evesfect/k-assist/internal/tools"
+)
+
+const (
+	// MaxTools is how many programs named in a prompt get their help text included
+	MaxTools = 3
+
+	// maxHelpBytes bounds the help text of a single program in the prompt
+	maxHelpBytes = 16 * 1024
+
+	// lookupTimeout bounds running --help or man for one program
+	lookupTimeout = 3 * time.Second
+
+	// lookupWaitDelay is how long to wait for output after a timed-out program is
+	// killed, in case a child it started still holds the output open
+	lookupWaitDelay = 500 * time.Millisecond
+)
+
+var (
+	// explicitTool matches a program the prompt clearly refers to, like "use ffmpeg" or `jq`
+	explicitTool = regexp.MustCompile("(?i)(?:\\b(?:use|using|with|via|run|running)\\s+|`)([a-z0-9][a-z0-9._+-]*)")
+
+	// promptWord matches words that could name a program
+	promptWord = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9._+-]*`)
+
+	overstrike = regexp.MustCompile(".\b")
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+	// subcommandHint matches help texts of programs with subcommands, whose flags
+	// depend on the subcommand and can't be checked against the top-level help
+	subcommandHint = regexp.MustCompile(`(?m)^\s*(?i:(?:available\s+)?(?:sub)?commands):|<command>|\[command\]|\bCOMMAND\b`)
+)
+
+// commonWords are English words that are also names of well-known programs. They
+// only count as programs when the prompt clearly refers to one, like "use make".
+var commonWords = map[string]bool{
+	"go": true, "just": true, "make": true, "tree": true,
+}
+
+// Mentioned returns the installed programs a prompt refers to, up to MaxTools. Since
+// their --help is run, other words only count when they name a well-known program, so
+// the wording of a prompt doesn't run programs like reset or reboot.
+func Mentioned(prompt string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string, explicit bool) {
+		name = strings.ToLower(strings.TrimRight(name, "."))
+		if len(names) >= MaxTools || seen[name] {
+			return
+		}
+		if !explicit && (!tools.IsKeyTool(name) || commonWords[name]) {
+			return
+		}
+		if _, err := exec.LookPath(name); err != nil {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	for _, m := range explicitTool.FindAllStringSubmatch(prompt, -1) {
+		add(m[1], true)
+	}
+	for _, word := range promptWord.FindAllString(prompt, -1) {
+		add(word, false)
+	}
+	return names
+}
+
+// Lookup returns the usage of a program: its --help output followed by its man page.
+// The program runs without input and is stopped if it takes too long. Use Excerpt to
+// shorten the result for a prompt.
+func Lookup(name string) (string, error) {
+	help, helpErr := run(name, "--help")
+	if strings.Contains(help, "--help all") {
+		// Some programs, like curl, only list common options unless asked for all of them
+		if all, err := run(name, "--help", "all"); err == nil && len(all) > len(help) {
+			help = all
+		}
+	}
+	manual, manErr := run("man", name)
+	if manErr != nil {
+		manual = ""
+	}
+
+	switch {
+	case help != "" && manual != "":
+		return help + "\n\n" + manual, nil
+	case manual != "":
+		return manual, nil
+	case help != "":
+		return help, nil
+	case helpErr != nil:
+		return "", helpErr
+	}
+	return "", manErr
+}
+
+func run(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out // Many programs print usage to stderr
+	cmd.Env = append(os.Environ(), "MANPAGER=cat", "PAGER=cat", "MANWIDTH=100", "NO_COLOR=1", "TERM=dumb")
+	cmd.WaitDelay = lookupWaitDelay
+	err := cmd.Run()
+
+	// Programs often exit non-zero after printing usage, so keep what they printed
+	text := ansiEscape.ReplaceAllString(overstrike.ReplaceAllString(out.String(), ""), "")
+	return strings.TrimSpace(text), err
+}
+
+// Excerpt shortens a help text from Lookup for the prompt
+func Excerpt(text string) string {
+	if len(text) <= maxHelpBytes {
+		return text
+	}
+	cut := strings.LastIndexByte(text[:maxHelpBytes], '\n')
+	if cut < 0 {
+		cut = maxHelpBytes
+	}
+	return text[:cut] + "\n[... rest of help text omitted ...]"
+}
+
+// Validate notes the flags in suggestions that don't appear in the help text of the
+// program they are passed to. docs maps program names to their help text. Programs
+// with subcommands are skipped, since their top-level help doesn't list every flag.
+func Validate(suggestions []llm.Suggestion, docs map[string]string) {
+	for i := range suggestions {
+		suggestions[i].UnknownFlags = nil
+		for _, args := range tools.Calls(suggestions[i].Command) {
+			doc, ok := docs[path.Base(args[0])]
+			if !ok || subcommandHint.MatchString(doc) {
+				continue
+			}
+			for _, arg := range args[1:] {
+				if arg == "--" {
+					break
+				}
+				if !strings.HasPrefix(arg, "-") || arg == "-" || knownFlag(arg, doc) {
+					continue
+				}
+				flag, _, _ := strings.Cut(arg, "=")
+				suggestions[i].UnknownFlags = append(suggestions[i].UnknownFlags, llm.UnknownFlag{Program: args[0], Flag: flag})
+			}
+		}
+	}
+}
+
+// knownFlag reports whether a flag appears in a help text. Combined short flags
+// like -rf are accepted if each letter is documented on its own.
+func knownFlag(arg string, doc string) bool {
+	flag, _, _ := strings.Cut(arg, "=")
+	if mentions(doc, flag) {
+		return true
+	}
+	if strings.HasPrefix(flag, "--") || len(flag) <= 2 {
+		return false
+	}
+
+	// A short flag with its value attached, like -n5 or -j4, or combined short flags
+	if mentions(doc, flag[:2]) && !isLetters(flag[2:]) {
+		return true
+	}
+	for _, c := range flag[1:] {
+		if !mentions(doc, "-"+string(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mentions reports whether flag appears in doc as a whole word
+func mentions(doc string, flag string) bool {
+	for start := 0; ; {
+		i := strings.Index(doc[start:], flag)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(flag)
+		before := i == 0 || !isFlagChar(doc[i-1])
+		after := end == len(doc) || !isFlagChar(doc[end])
+		if before && after {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isFlagChar(c byte) bool {
+	return c == '-' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isLetters(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+const (
+	DefaultClaudeBaseURL = "https://api.anthropic.com"
+	claudeAPIVersion     = "2023-06-01"
+)
+
+// Claude implementation using the Anthropic Messages API
+type claudeClient struct {
+	httpClient *http.Client
+	baseURL    string
+	config     *config.Config
+}
+
+type claudeMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type claudeRequest struct {
+	Model         string          `json:"model"`
+	System        string          `json:"system,omitempty"`
+	Messages      []claudeMessage `json:"messages"`
+	MaxTokens     int             `json:"max_tokens"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+}
+
+type claudeResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+// claudeStreamEvent is the data payload of a server-sent event from a streaming request
+type claudeStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"` // Set in the message_delta event
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type claudeErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ClaudeError is returned when the Anthropic API responds with a non-2xx status
+type ClaudeError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	RetryAfter time.Duration // From the Retry-After header, if the API sent one
+}
+
+func (e *ClaudeError) Error() string {
+	var reason string
+	switch e.Type {
+	case "authentication_error":
+		reason = "invalid API key"
+	case "permission_error":
+		reason = "API key lacks permission for this request"
+	case "not_found_error":
+		reason = "model or endpoint not found"
+	case "rate_limit_error":
+		reason = "rate limit exceeded"
+	case "overloaded_error":
+		reason = "API is temporarily overloaded"
+	case "invalid_request_error":
+		reason = "invalid request"
+	default:
+		reason = "API error"
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("%s (status %d)", reason, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d): %s", reason, e.StatusCode, e.Message)
+}
+
+func newClaudeClient(cfg *config.Config) *claudeClient {
+	baseURL := cfg.LLM.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultClaudeBaseURL
+	}
+	return &claudeClient{
+		httpClient: newHTTPClient(cfg),
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		config:     cfg,
+	}
+}
+
+// Complete uses the Messages API. Claude has no JSON mode, so JSON replies rely on the system prompt.
+func (c *claudeClient) Complete(ctx context.Context, req Request) (string, error) {
+	messages := make([]claudeMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = claudeMessage{Role: msg.Role, Content: msg.Content}
+	}
+
+	resp, err := c.send(ctx, claudeRequest{
+		Model:         c.config.LLM.Model,
+		System:        req.System,
+		Messages:      messages,
+		MaxTokens:     req.maxTokens(c.config),
+		Temperature:   req.Temperature,
+		StopSequences: req.Stop,
+		Stream:        req.Stream != nil,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if req.Stream != nil {
+		return c.readStream(resp, req.Stream)
+	}
+
+	var msg claudeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return "", fmt.Errorf("parsing claude response: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range msg.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if msg.StopReason == "max_tokens" {
+		return text.String(), fmt.Errorf("claude: %w", ErrTruncated)
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no valid text response from Claude")
+	}
+
+	return text.String(), nil
+}
+
+// readStream writes the text of a streamed reply to w as it arrives and returns all of it
+func (c *claudeClient) readStream(resp *http.Response, w io.Writer) (string, error) {
+	var reply strings.Builder
+	var stopReason string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event claudeStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return reply.String(), fmt.Errorf("parsing claude stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				reply.WriteString(event.Delta.Text)
+				if _, err := io.WriteString(w, event.Delta.Text); err != nil {
+					return reply.String(), err
+				}
+			}
+		case "error":
+			return reply.String(), fmt.Errorf("claude request failed: %w", &ClaudeError{
+				StatusCode: resp.StatusCode,
+				Type:       event.Error.Type,
+				Message:    event.Error.Message,
+			})
+		case "message_delta":
+			stopReason = event.Delta.StopReason
+		case "message_stop":
+			if stopReason == "max_tokens" {
+				return reply.String(), fmt.Errorf("claude: %w", ErrTruncated)
+			}
+			return reply.String(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply.String(), fmt.Errorf("reading claude stream: %w", err)
+	}
+
+	return reply.String(), nil
+}
+
+// send posts a request to the Messages API, returning the response only if it succeeded
+func (c *claudeClient) send(ctx context.Context, request claudeRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding claude request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating claude request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.config.LLM.APIKey)
+	req.Header.Set("anthropic-version", claudeAPIVersion)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("claude request failed: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &ClaudeError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header)}
+		var errResp claudeErrorResponse
+		if respBody, err := io.ReadAll(resp.Body); err == nil && json.Unmarshal(respBody, &errResp) == nil {
+			apiErr.Type = errResp.Error.Type
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, fmt.Errorf("claude request failed: %w", apiErr)
+	}
+
+	return resp, nil
+}
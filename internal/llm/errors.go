@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/config"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	openai "github.com/sashabaranov/go-openai"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrorKind tells what went wrong with an LLM request, so kass can retry it or
+// tell the user how to fix it
+type ErrorKind string
+
+const (
+	ErrorAuth          ErrorKind = "authentication"
+	ErrorQuota         ErrorKind = "quota"
+	ErrorRateLimit     ErrorKind = "rate limit"
+	ErrorSafety        ErrorKind = "safety block"
+	ErrorContextLength ErrorKind = "context too long"
+	ErrorTransient     ErrorKind = "transient"
+	ErrorTruncated     ErrorKind = "reply cut off"
+)
+
+// ErrTruncated is returned when the reply was cut off by the max_tokens limit
+var ErrTruncated = errors.New("the reply was cut off by the max_tokens limit")
+
+const (
+	// maxRetries is how many times a rate-limited or transient failure is retried
+	maxRetries = 3
+
+	// baseRetryWait is the wait before the first retry, doubled for each one after it
+	baseRetryWait = time.Second
+
+	// maxRetryWait is the longest kass waits before a retry. Providers asking for
+	// longer waits are not retried.
+	maxRetryWait = time.Minute
+)
+
+// Error is a failed LLM request whose cause was recognized
+type Error struct {
+	Kind       ErrorKind
+	Provider   string
+	RetryAfter time.Duration // How long the provider asked to wait before retrying, if it said
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether sending the same request again may succeed
+func (e *Error) Retryable() bool {
+	return e.Kind == ErrorRateLimit || e.Kind == ErrorTransient
+}
+
+// Hint tells the user what they can do about the error
+func (e *Error) Hint() string {
+	switch e.Kind {
+	case ErrorAuth:
+		return fmt.Sprintf("Check llm.api_key in the config file or the KASS_%s_API_KEY environment variable.", e.Provider)
+	case ErrorQuota:
+		return "The account has run out of quota or credits, check its plan and billing with the provider."
+	case ErrorRateLimit:
+		return "The provider is rate limiting requests, wait a minute and try again."
+	case ErrorSafety:
+		return "The provider blocked the request for safety reasons. Rephrase the prompt, or leave out " +
+			"directory contents (-a, -A, -r) that may contain sensitive data."
+	case ErrorContextLength:
+		return "The prompt is too long for the model. Use -r instead of -A, or set context.window_tokens " +
+			"in the config file to the model's real context window."
+	case ErrorTransient:
+		return "The provider is having problems, try again later."
+	case ErrorTruncated:
+		return fmt.Sprintf("Raise max_tokens in the config file, the default is %d.", config.DefaultMaxTokens)
+	}
+	return ""
+}
+
+// Hint returns what the user can do about a failed LLM request, or "" if the cause is unknown
+func Hint(err error) string {
+	var llmErr *Error
+	if errors.As(err, &llmErr) {
+		return llmErr.Hint()
+	}
+	return ""
+}
+
+// classify wraps err in an *Error if its cause is recognized, and returns it unchanged otherwise
+func classify(provider string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var llmErr *Error
+	if errors.As(err, &llmErr) {
+		return err
+	}
+
+	kind, retryAfter := classifyCause(err)
+	if kind == "" {
+		return err
+	}
+	return &Error{Kind: kind, Provider: provider, RetryAfter: retryAfter, Err: err}
+}
+
+func classifyCause(err error) (ErrorKind, time.Duration) {
+	if errors.Is(err, ErrTruncated) {
+		return ErrorTruncated, 0
+	}
+
+	var claudeErr *ClaudeError
+	if errors.As(err, &claudeErr) {
+		switch {
+		case claudeErr.Type == "overloaded_error":
+			return ErrorTransient, claudeErr.RetryAfter
+		case strings.Contains(claudeErr.Message, "credit balance"):
+			return ErrorQuota, 0
+		case strings.Contains(claudeErr.Message, "prompt is too long"):
+			return ErrorContextLength, 0
+		}
+		return statusKind(claudeErr.StatusCode), claudeErr.RetryAfter
+	}
+
+	var ollamaErr *OllamaError
+	if errors.As(err, &ollamaErr) {
+		return statusKind(ollamaErr.StatusCode), ollamaErr.RetryAfter
+	}
+
+	var openAIErr *openai.APIError
+	if errors.As(err, &openAIErr) {
+		code := fmt.Sprint(openAIErr.Code)
+		switch {
+		case code == "insufficient_quota":
+			return ErrorQuota, 0
+		case code == "context_length_exceeded", isContextLengthMessage(openAIErr.Message):
+			return ErrorContextLength, 0
+		case code == "content_filter", code == "content_policy_violation":
+			return ErrorSafety, 0
+		}
+		return statusKind(openAIErr.HTTPStatusCode), openAIRetryAfter(err)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return statusKind(requestErr.HTTPStatusCode), openAIRetryAfter(err)
+	}
+
+	var blockedErr *genai.BlockedError
+	if errors.As(err, &blockedErr) {
+		return ErrorSafety, 0
+	}
+	if apiErr, ok := apierror.FromError(err); ok {
+		var retryAfter time.Duration
+		if info := apiErr.Details().RetryInfo; info != nil {
+			retryAfter = info.GetRetryDelay().AsDuration()
+		}
+		if isContextLengthMessage(apiErr.Error()) {
+			return ErrorContextLength, 0
+		}
+		if code := apiErr.HTTPCode(); code > 0 {
+			return statusKind(code), retryAfter
+		}
+		if status := apiErr.GRPCStatus(); status != nil {
+			switch status.Code() {
+			case codes.Unauthenticated, codes.PermissionDenied:
+				return ErrorAuth, 0
+			case codes.ResourceExhausted:
+				return ErrorRateLimit, retryAfter
+			case codes.Unavailable, codes.Internal, codes.Aborted:
+				return ErrorTransient, retryAfter
+			case codes.InvalidArgument:
+				if strings.Contains(status.Message(), "API key") {
+					return ErrorAuth, 0
+				}
+			}
+		}
+	}
+	return "", 0
+}
+
+// retryAfterError carries the Retry-After of a response whose error type has no room for it
+type retryAfterError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// openAIRetryAfter returns the Retry-After recorded for a failed OpenAI request, if any
+func openAIRetryAfter(err error) time.Duration {
+	var retryErr *retryAfterError
+	if errors.As(err, &retryErr) {
+		return retryErr.retryAfter
+	}
+	return 0
+}
+
+// statusKind classifies an HTTP status code, returning "" for codes that don't tell
+func statusKind(code int) ErrorKind {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorAuth
+	case http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529: // 529 is Anthropic's "overloaded"
+		return ErrorTransient
+	}
+	return ""
+}
+
+func isContextLengthMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, phrase := range []string{"maximum context length", "context length", "prompt is too long", "too many tokens", "exceeds the maximum number of tokens"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// retryWait returns how long to wait before a retry: what the provider asked for,
+// or an exponential backoff with jitter so clients don't retry in lockstep
+func retryWait(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	wait := baseRetryWait << attempt
+	return wait/2 + rand.N(wait/2+1)
+}
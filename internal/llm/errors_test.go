@@ -0,0 +1,36 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+func TestOpenAIRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error": {"message": "Rate limit reached", "type": "requests", "code": "rate_limit_exceeded"}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{LLM: config.LLMConfig{Provider: "openai", APIKey: "test", Model: "gpt-4o", BaseURL: server.URL}}
+	_, err := newOpenAIClient(cfg).Complete(context.Background(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+
+	var llmErr *Error
+	if !errors.As(classify("openai", err), &llmErr) {
+		t.Fatalf("classify(%v) is not an *Error", err)
+	}
+	if llmErr.Kind != ErrorRateLimit {
+		t.Errorf("Kind = %q, want %q", llmErr.Kind, ErrorRateLimit)
+	}
+	if llmErr.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %v, want 7s", llmErr.RetryAfter)
+	}
+}
@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Failure describes an error the user wants assistance with. Failures of executed
+// commands carry the command, its exit code and the tail of its stderr; other
+// failures (e.g. a failed LLM request) only have a Message.
+type Failure struct {
+	Message  string
+	Command  string
+	Dir      string
+	ExitCode int
+	Stderr   string // Last part of the command's stderr
+	Duration time.Duration
+}
+
+func (f *Failure) Error() string {
+	if f.Command == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("command %q failed: %s", f.Command, f.Message)
+}
+
+// String formats the failure for inclusion in a prompt
+func (f Failure) String() string {
+	if f.Command == "" {
+		return f.Message
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Command: %s\n", f.Command)
+	if f.Dir != "" {
+		fmt.Fprintf(&sb, "Working directory: %s\n", f.Dir)
+	}
+	fmt.Fprintf(&sb, "Exit code: %d\n", f.ExitCode)
+	fmt.Fprintf(&sb, "Duration: %s\n", f.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&sb, "Error: %s\n", f.Message)
+	if f.Stderr != "" {
+		fmt.Fprintf(&sb, "Stderr (tail):\n%s\n", strings.TrimRight(f.Stderr, "\n"))
+	}
+	return sb.String()
+}
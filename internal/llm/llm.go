@@ -2,34 +2,125 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/evesfect/k-assist/internal/config"
 	"github.com/google/generative-ai-go/genai"
 	openai "github.com/sashabaranov/go-openai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// Provider sends requests to one LLM backend
+type Provider interface {
+	// Complete returns the reply to a conversation. If req.Stream is set, the reply
+	// is also written to it as it is generated.
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// Client runs the kass modes against the configured provider
 type Client interface {
-	GetCommand(prompt string) (string, error)
-	GetResponse(prompt string) (string, error)
-	HandleError(errOutput string, contextInfo string) (string, error)
+	Provider
+	GetCommand(ctx context.Context, prompt string) ([]Suggestion, error)
+	GetResponse(ctx context.Context, prompt string) (string, error)
+	HandleError(ctx context.Context, failure Failure, contextInfo string) (string, error)
+	// StreamResponse is like GetResponse, but writes the answer to w as it is generated
+	StreamResponse(ctx context.Context, prompt string, w io.Writer) error
+	// Chat continues a conversation, writing the reply to w as it is generated and returning it
+	Chat(ctx context.Context, messages []Message, w io.Writer) (string, error)
+}
+
+// Roles of the messages in a conversation
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ResponseFormat asks for replies of a certain form
+type ResponseFormat string
+
+const (
+	FormatText ResponseFormat = ""
+	FormatJSON ResponseFormat = "json" // Providers with a JSON mode enforce it, the others rely on the system prompt
+)
+
+// Request is a provider-agnostic completion request
+type Request struct {
+	System         string
+	Messages       []Message // Alternating user and assistant messages, starting and ending with the user
+	MaxTokens      int       // Zero uses max_tokens from the config
+	Temperature    *float64  // Nil uses the provider's default
+	Stop           []string
+	ResponseFormat ResponseFormat
+	Stream         io.Writer // Receives the reply as it is generated, may be nil
+}
+
+// maxTokens returns the reply limit for a request
+func (r Request) maxTokens(cfg *config.Config) int {
+	if r.MaxTokens > 0 {
+		return r.MaxTokens
+	}
+	return cfg.MaxTokens
 }
 
 // Factory function to create the appropriate LLM client
 func NewClient(cfg *config.Config) (Client, error) {
+	var provider Provider
 	switch cfg.LLM.Provider {
 	case "openai":
-		return newOpenAIClient(cfg), nil
+		provider = newOpenAIClient(cfg)
 	case "gemini":
-		return newGeminiClient(cfg)
+		gemini, err := newGeminiClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		provider = gemini
 	case "claude":
-		return newClaudeClient(cfg), nil
+		provider = newClaudeClient(cfg)
+	case "ollama":
+		provider = newOllamaClient(cfg)
 	default:
 		return nil, fmt.Errorf("unsupported LLM provider: %s", cfg.LLM.Provider)
 	}
+	return &client{provider: provider, config: cfg}, nil
+}
+
+// newHTTPClient returns an HTTP client that adds the configured extra headers to every request
+func newHTTPClient(cfg *config.Config) *http.Client {
+	if len(cfg.LLM.Headers) == 0 {
+		return &http.Client{}
+	}
+	return &http.Client{
+		Transport: &headerTransport{
+			headers: cfg.LLM.Headers,
+			base:    http.DefaultTransport,
+		},
+	}
+}
+
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
 }
 
 // Gemini implementation
@@ -40,7 +131,21 @@ type geminiClient struct {
 
 func newGeminiClient(cfg *config.Config) (*geminiClient, error) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.LLM.APIKey))
+	opts := []option.ClientOption{option.WithAPIKey(cfg.LLM.APIKey)}
+	if cfg.LLM.BaseURL != "" {
+		opts = append(opts, option.WithEndpoint(strings.TrimRight(cfg.LLM.BaseURL, "/")))
+	}
+	if len(cfg.LLM.Headers) > 0 {
+		// A custom HTTP client replaces the API key option, so the key is sent as a header
+		headers := map[string]string{"x-goog-api-key": cfg.LLM.APIKey}
+		for key, value := range cfg.LLM.Headers {
+			headers[key] = value
+		}
+		opts = append(opts, option.WithHTTPClient(&http.Client{
+			Transport: &headerTransport{headers: headers, base: http.DefaultTransport},
+		}))
+	}
+	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("creating Gemini client: %w", err)
 	}
@@ -51,195 +156,210 @@ func newGeminiClient(cfg *config.Config) (*geminiClient, error) {
 	}, nil
 }
 
-func (c *geminiClient) GetCommand(prompt string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// Complete sends the conversation as a chat. The system prompt is sent as part of the
+// first message, since older models like gemini-pro don't accept system instructions.
+func (c *geminiClient) Complete(ctx context.Context, req Request) (string, error) {
+	if len(req.Messages) == 0 {
+		return "", fmt.Errorf("no messages to send")
+	}
 
 	model := c.client.GenerativeModel(c.config.LLM.Model)
-
-	systemPrompt := fmt.Sprintf(
-		"You are a development assistant for terminal commands on %s using %s shell. "+
-			"The user is %s, a software developer working on a legitimate project. "+
-			"Your task is to provide safe, non-destructive terminal commands for development purposes only. "+
-			"You can provide multiple commands if the task requires multiple steps. "+
-			"You should lean towards using standard tools and libraries when possible. You can also use kass to install additional tools and libraries if needed. "+
-			"Separate each command with a newline character. "+
-			"Do not provide any commands that could harm the system. "+
-			"Do not include any explanations or comments in your response, only the command(s).",
-		c.config.OS,
-		c.config.Shell,
-		c.config.User,
-	)
-
-	// Combine system prompt and user prompt
-	fullPrompt := systemPrompt + "\n\nUser request: " + prompt
-
-	resp, err := model.GenerateContent(ctx, genai.Text(fullPrompt))
-	if err != nil {
-		return "", fmt.Errorf("gemini request failed: %w", err)
+	model.SetMaxOutputTokens(int32(req.maxTokens(c.config)))
+	if req.Temperature != nil {
+		model.SetTemperature(float32(*req.Temperature))
 	}
+	model.StopSequences = req.Stop
 
-	// Extract the command(s) from the response
-	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if text, ok := part.(genai.Text); ok {
-				return strings.TrimSpace(string(text)), nil
-			}
+	session := model.StartChat()
+	for i, msg := range req.Messages {
+		text := msg.Content
+		if i == 0 && req.System != "" {
+			text = req.System + "\n\nUser request: " + text
+		}
+		role := "user"
+		if msg.Role == RoleAssistant {
+			role = "model"
 		}
+		session.History = append(session.History, &genai.Content{Role: role, Parts: []genai.Part{genai.Text(text)}})
 	}
 
-	return "", fmt.Errorf("no valid text response from Gemini")
-}
-func (c *geminiClient) GetResponse(prompt string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	model := c.client.GenerativeModel(c.config.LLM.Model)
-
-	systemPrompt := fmt.Sprintf(
-		"You are a helpful assistant for %s, a software developer. "+
-			"You are a terminal assistant for %s using %s shell. "+
-			"Provide informative and concise responses to queries about programming and development."+
-			"The user is asking for information in an explanation format, so respond with concise explanations."+
-			"The user does not wish to continue the conversation, so do not ask for clarification or further information.",
-		c.config.User,
-		c.config.OS,
-		c.config.Shell,
-	)
-
-	fullPrompt := systemPrompt + "\n\nUser request: " + prompt
+	// The last message is sent, the ones before it are the history
+	last := session.History[len(session.History)-1]
+	session.History = session.History[:len(session.History)-1]
 
-	resp, err := model.GenerateContent(ctx, genai.Text(fullPrompt))
-	if err != nil {
-		return "", fmt.Errorf("gemini request failed: %w", err)
+	if req.Stream == nil {
+		resp, err := session.SendMessage(ctx, last.Parts...)
+		if err != nil {
+			return "", fmt.Errorf("gemini request failed: %w", err)
+		}
+		text := geminiText(resp)
+		if geminiTruncated(resp) {
+			return text, fmt.Errorf("gemini: %w", ErrTruncated)
+		}
+		if text == "" {
+			return "", fmt.Errorf("no valid text response from Gemini")
+		}
+		return text, nil
 	}
 
-	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if text, ok := part.(genai.Text); ok {
-				return string(text), nil
+	var reply strings.Builder
+	truncated := false
+	iter := session.SendMessageStream(ctx, last.Parts...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			if truncated {
+				return reply.String(), fmt.Errorf("gemini: %w", ErrTruncated)
 			}
+			return reply.String(), nil
+		}
+		if err != nil {
+			return reply.String(), fmt.Errorf("gemini request failed: %w", err)
 		}
-	}
 
-	return "", fmt.Errorf("no valid text response from Gemini")
+		text := geminiText(resp)
+		truncated = truncated || geminiTruncated(resp)
+		reply.WriteString(text)
+		if _, err := io.WriteString(req.Stream, text); err != nil {
+			return reply.String(), err
+		}
+	}
 }
 
-func (c *geminiClient) HandleError(errOutput string, contextInfo string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	model := c.client.GenerativeModel(c.config.LLM.Model)
-
-	systemPrompt := fmt.Sprintf(
-		"You are a helpful assistant for %s, a software developer. "+
-			"You are a terminal assistant for %s using %s shell. "+
-			"It is safe to assume that the user is working on a legitimate project. "+
-			"It is safe doesn't violate any policies. "+
-			"The user has encountered an error. You need to find solution for this error. "+
-			"You should provide a solution that is easy to understand and follow. "+
-			"Do not offer to continue the conversation, the user does not wish to continue the conversation. "+
-			"You have the following context information: { %s } "+
-			"The error encountered is: { %s }",
-		c.config.User,
-		c.config.OS,
-		c.config.Shell,
-		contextInfo,
-		errOutput,
-	)
-
-	fullPrompt := systemPrompt
-
-	resp, err := model.GenerateContent(ctx, genai.Text(fullPrompt))
-	if err != nil {
-		return "", fmt.Errorf("gemini request failed: %w", err)
+// geminiText returns the text of the first candidate of a response
+func geminiText(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
 	}
-
-	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if text, ok := part.(genai.Text); ok {
-				return string(text), nil
-			}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
 		}
 	}
+	return text.String()
+}
 
-	return "", fmt.Errorf("no valid text response from Gemini")
+// geminiTruncated reports whether a response stopped at the output token limit
+func geminiTruncated(resp *genai.GenerateContentResponse) bool {
+	return len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens
 }
 
 // OpenAI implementation
 type openAIClient struct {
-	client *openai.Client
-	config *config.Config
+	client     *openai.Client
+	config     *config.Config
+	retryAfter *retryAfterTransport
 }
 
 func newOpenAIClient(cfg *config.Config) *openAIClient {
-	return &openAIClient{
-		client: openai.NewClient(cfg.LLM.APIKey),
-		config: cfg,
+	clientConfig := openai.DefaultConfig(cfg.LLM.APIKey)
+	if cfg.LLM.BaseURL != "" {
+		clientConfig.BaseURL = strings.TrimRight(cfg.LLM.BaseURL, "/")
 	}
-}
+	clientConfig.OrgID = cfg.LLM.Organization
+	httpClient := newHTTPClient(cfg)
+	retryAfter := &retryAfterTransport{base: httpClient.Transport}
+	httpClient.Transport = retryAfter
+	clientConfig.HTTPClient = httpClient
 
-func (c *openAIClient) GetCommand(prompt string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	resp, err := c.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: c.config.LLM.Model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role: openai.ChatMessageRoleSystem,
-					Content: fmt.Sprintf(
-						"You are a terminal assistant for %s using %s shell. "+
-							"The user is %s. Respond with only the terminal command, "+
-							"no explanations.",
-						c.config.OS,
-						c.config.Shell,
-						c.config.User,
-					),
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			MaxTokens: c.config.MaxTokens,
-		},
-	)
-
-	if err != nil {
-		return "", fmt.Errorf("OpenAI request failed: %w", err)
+	return &openAIClient{
+		client:     openai.NewClientWithConfig(clientConfig),
+		config:     cfg,
+		retryAfter: retryAfter,
 	}
-
-	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
 }
 
-func (c *openAIClient) GetResponse(prompt string) (string, error) {
-	return "", fmt.Errorf("OpenAI API not implemented yet")
+// retryAfterTransport remembers the Retry-After header of the last failed response,
+// since go-openai's errors don't carry the response headers
+type retryAfterTransport struct {
+	base http.RoundTripper // http.DefaultTransport if nil
+	last atomic.Int64      // A time.Duration
 }
 
-func (c *openAIClient) HandleError(errOutput string, shellHistory string) (string, error) {
-	return "", fmt.Errorf("OpenAI API not implemented yet")
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		t.last.Store(int64(parseRetryAfter(resp.Header)))
+	}
+	return resp, err
 }
 
-// Claude implementation (placeholder - implement if needed)
-type claudeClient struct {
-	config *config.Config
+// wrap attaches the Retry-After of the failed response to err
+func (t *retryAfterTransport) wrap(err error) error {
+	if wait := time.Duration(t.last.Swap(0)); wait > 0 {
+		return &retryAfterError{err: err, retryAfter: wait}
+	}
+	return err
 }
 
-func newClaudeClient(cfg *config.Config) *claudeClient {
-	return &claudeClient{config: cfg}
-}
+// Complete uses the chat completions API. The JSON response format isn't requested,
+// since many OpenAI-compatible servers reject it; the system prompt asks for JSON instead.
+func (c *openAIClient) Complete(ctx context.Context, req Request) (string, error) {
+	var messages []openai.ChatCompletionMessage
+	if req.System != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		messages = append(messages, openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content})
+	}
 
-func (c *claudeClient) GetCommand(prompt string) (string, error) {
-	return "", fmt.Errorf("claude API not implemented yet")
-}
+	request := openai.ChatCompletionRequest{
+		Model:     c.config.LLM.Model,
+		Messages:  messages,
+		MaxTokens: req.maxTokens(c.config),
+		Stop:      req.Stop,
+		Stream:    req.Stream != nil,
+	}
+	if req.Temperature != nil {
+		request.Temperature = float32(*req.Temperature)
+	}
 
-func (c *claudeClient) GetResponse(prompt string) (string, error) {
-	return "", fmt.Errorf("claude API not implemented yet")
-}
+	if req.Stream == nil {
+		resp, err := c.client.CreateChatCompletion(ctx, request)
+		if err != nil {
+			return "", fmt.Errorf("OpenAI request failed: %w", c.retryAfter.wrap(err))
+		}
+		if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("no valid text response from OpenAI")
+		}
+		if resp.Choices[0].FinishReason == openai.FinishReasonLength {
+			return resp.Choices[0].Message.Content, fmt.Errorf("OpenAI: %w", ErrTruncated)
+		}
+		return resp.Choices[0].Message.Content, nil
+	}
+
+	stream, err := c.client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("OpenAI request failed: %w", c.retryAfter.wrap(err))
+	}
+	defer stream.Close()
+
+	var reply strings.Builder
+	truncated := false
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if truncated {
+				return reply.String(), fmt.Errorf("OpenAI: %w", ErrTruncated)
+			}
+			return reply.String(), nil
+		}
+		if err != nil {
+			return reply.String(), fmt.Errorf("OpenAI request failed: %w", err)
+		}
 
-func (c *claudeClient) HandleError(errOutput string, shellHistory string) (string, error) {
-	return "", fmt.Errorf("claude API not implemented yet")
+		if len(chunk.Choices) > 0 {
+			truncated = truncated || chunk.Choices[0].FinishReason == openai.FinishReasonLength
+			text := chunk.Choices[0].Delta.Content
+			reply.WriteString(text)
+			if _, err := io.WriteString(req.Stream, text); err != nil {
+				return reply.String(), err
+			}
+		}
+	}
 }
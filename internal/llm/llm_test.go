@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+func TestClaudeRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %q, want /v1/messages", r.URL.Path)
+		}
+		if got := r.Header.Get("x-api-key"); got != "test-key" {
+			t.Errorf("x-api-key = %q, want test-key", got)
+		}
+		if got := r.Header.Get("X-Team"); got != "platform" {
+			t.Errorf("X-Team = %q, want platform", got)
+		}
+
+		var req claudeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		if req.Model != "claude-test" || len(req.Messages) != 1 || req.Messages[0].Content != "hello" {
+			t.Errorf("request = %+v, want one message %q to claude-test", req, "hello")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content": [{"type": "text", "text": "hi there"}]}`))
+	}))
+	defer server.Close()
+
+	got := roundTrip(t, config.LLMConfig{Provider: "claude", APIKey: "test-key", Model: "claude-test", BaseURL: server.URL})
+	if got != "hi there" {
+		t.Errorf("reply = %q, want %q", got, "hi there")
+	}
+}
+
+func TestOpenAIRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %q, want /chat/completions", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q, want Bearer test-key", got)
+		}
+		if got := r.Header.Get("X-Team"); got != "platform" {
+			t.Errorf("X-Team = %q, want platform", got)
+		}
+
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		last := req.Messages[len(req.Messages)-1]
+		if req.Model != "gpt-test" || last.Role != RoleUser || last.Content != "hello" {
+			t.Errorf("request = %+v, want message %q to gpt-test", req, "hello")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "hi there"}}]}`))
+	}))
+	defer server.Close()
+
+	got := roundTrip(t, config.LLMConfig{Provider: "openai", APIKey: "test-key", Model: "gpt-test", BaseURL: server.URL})
+	if got != "hi there" {
+		t.Errorf("reply = %q, want %q", got, "hi there")
+	}
+}
+
+func TestClaudeMaxTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content": [{"type": "text", "text": "{\"commands\": [{\"command\": \"du -ah"}], "stop_reason": "max_tokens"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{LLM: config.LLMConfig{Provider: "claude", APIKey: "test-key", Model: "claude-test", BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	suggestions, err := client.GetCommand(context.Background(), "hello")
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("GetCommand = %v, %v, want ErrTruncated", suggestions, err)
+	}
+	if !strings.Contains(Hint(err), "max_tokens") {
+		t.Errorf("Hint = %q, want it to mention max_tokens", Hint(err))
+	}
+}
+
+// roundTrip sends "hello" through a client for llm with an extra X-Team header and returns the reply
+func roundTrip(t *testing.T, llm config.LLMConfig) string {
+	t.Helper()
+	llm.Headers = map[string]string{"X-Team": "platform"}
+	client, err := NewClient(&config.Config{LLM: llm})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	reply, err := client.GetResponse(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("GetResponse: %v", err)
+	}
+	return reply
+}
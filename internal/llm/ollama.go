@@ -0,0 +1,236 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+const DefaultOllamaBaseURL = "http://localhost:11434"
+
+// Ollama implementation using the native /api/chat endpoint
+type ollamaClient struct {
+	httpClient *http.Client
+	baseURL    string
+	config     *config.Config
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []ollamaMessage `json:"messages"`
+	Stream    bool            `json:"stream"`
+	Format    string          `json:"format,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"`
+	Options   ollamaOptions   `json:"options"`
+}
+
+type ollamaChatResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"` // "length" when num_predict was reached
+	Error      string        `json:"error"`
+}
+
+// ErrOllamaModelNotPulled is returned when the configured model is not available locally
+var ErrOllamaModelNotPulled = errors.New("model is not available locally")
+
+// OllamaError is returned when the Ollama server reports an error
+type OllamaError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // From the Retry-After header of a proxy in front of Ollama, if any
+}
+
+func (e *OllamaError) Error() string {
+	return fmt.Sprintf("ollama request failed (status %d): %s", e.StatusCode, e.Message)
+}
+
+func newOllamaClient(cfg *config.Config) *ollamaClient {
+	baseURL := cfg.LLM.BaseURL
+	if baseURL == "" {
+		baseURL = ollamaHostFromEnv()
+	}
+	return &ollamaClient{
+		httpClient: newHTTPClient(cfg),
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		config:     cfg,
+	}
+}
+
+// ollamaHostFromEnv honours OLLAMA_HOST the same way the ollama CLI does
+func ollamaHostFromEnv() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		return DefaultOllamaBaseURL
+	}
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return host
+}
+
+// Complete uses /api/chat, with Ollama's JSON mode when JSON is requested
+func (c *ollamaClient) Complete(ctx context.Context, req Request) (string, error) {
+	var messages []ollamaMessage
+	if req.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		messages = append(messages, ollamaMessage{Role: msg.Role, Content: msg.Content})
+	}
+
+	request := ollamaChatRequest{
+		Model:     c.config.LLM.Model,
+		Messages:  messages,
+		Stream:    req.Stream != nil,
+		KeepAlive: ollamaKeepAlive(c.config.LLM.KeepAlive),
+		Options: ollamaOptions{
+			NumPredict:  req.maxTokens(c.config),
+			NumCtx:      c.config.Context.WindowTokens,
+			Temperature: req.Temperature,
+			Stop:        req.Stop,
+		},
+	}
+	if req.ResponseFormat == FormatJSON {
+		request.Format = "json"
+	}
+
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if req.Stream != nil {
+		return c.readStream(resp, req.Stream)
+	}
+
+	var chatResp ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("parsing ollama response: %w", err)
+	}
+	if chatResp.Error != "" {
+		return "", c.responseError(resp.StatusCode, chatResp.Error)
+	}
+	if chatResp.DoneReason == "length" {
+		return chatResp.Message.Content, fmt.Errorf("ollama: %w", ErrTruncated)
+	}
+	if chatResp.Message.Content == "" {
+		return "", fmt.Errorf("no valid text response from Ollama")
+	}
+
+	return chatResp.Message.Content, nil
+}
+
+// readStream writes the text of a streamed reply to w as it arrives and returns all of it
+func (c *ollamaClient) readStream(resp *http.Response, w io.Writer) (string, error) {
+	var reply strings.Builder
+
+	// Streaming responses are newline-delimited JSON objects
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ollamaChatResponse
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			return reply.String(), nil
+		} else if err != nil {
+			return reply.String(), fmt.Errorf("parsing ollama stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return reply.String(), c.responseError(resp.StatusCode, chunk.Error)
+		}
+		reply.WriteString(chunk.Message.Content)
+		if _, err := io.WriteString(w, chunk.Message.Content); err != nil {
+			return reply.String(), err
+		}
+		if chunk.Done {
+			if chunk.DoneReason == "length" {
+				return reply.String(), fmt.Errorf("ollama: %w", ErrTruncated)
+			}
+			return reply.String(), nil
+		}
+	}
+}
+
+// send posts a request to /api/chat, returning the response only if it succeeded
+func (c *ollamaClient) send(ctx context.Context, request ollamaChatRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding ollama request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("ollama request failed: no server at %s (is `ollama serve` running?): %w", c.baseURL, err)
+		}
+		return nil, fmt.Errorf("ollama request failed: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var errResp ollamaChatResponse
+		if respBody, err := io.ReadAll(resp.Body); err == nil {
+			json.Unmarshal(respBody, &errResp)
+		}
+		err := c.responseError(resp.StatusCode, errResp.Error)
+		var ollamaErr *OllamaError
+		if errors.As(err, &ollamaErr) {
+			ollamaErr.RetryAfter = parseRetryAfter(resp.Header)
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// responseError maps an Ollama error response to an error, detecting models that still need to be pulled
+func (c *ollamaClient) responseError(statusCode int, message string) error {
+	if statusCode == http.StatusNotFound || strings.Contains(message, "try pulling it first") {
+		return fmt.Errorf("ollama request failed: %w: run `ollama pull %s`", ErrOllamaModelNotPulled, c.config.LLM.Model)
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return &OllamaError{StatusCode: statusCode, Message: message}
+}
+
+// ollamaKeepAlive converts the configured keep_alive into the form the API expects.
+// Durations like "5m" are sent as strings, bare numbers (seconds, or -1 for forever) as numbers.
+func ollamaKeepAlive(keepAlive string) any {
+	if keepAlive == "" {
+		return nil
+	}
+	if seconds, err := strconv.Atoi(keepAlive); err == nil {
+		return seconds
+	}
+	return keepAlive
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+// commandSystemPrompt returns the system prompt used when asking for terminal commands
+func commandSystemPrompt(cfg *config.Config) string {
+	return fmt.Sprintf(
+		"You are a development assistant for terminal commands on %s using %s shell. "+
+			"The user is %s, a software developer working on a legitimate project. "+
+			"Your task is to provide safe, non-destructive terminal commands for development purposes only. "+
+			"You can provide multiple commands if the task requires multiple steps. "+
+			"You should lean towards using standard tools and libraries when possible. You can also use kass to install additional tools and libraries if needed. "+
+			"Do not provide any commands that could harm the system. "+
+			"Respond only with a JSON object of the form %s, with one entry per command in the order they should be run. "+
+			"A command may span multiple lines, for example when it uses a heredoc. "+
+			"The description briefly explains what the command does. "+
+			"The risk is \"safe\" for read-only commands, \"modifies\" for commands that change files, packages or settings, "+
+			"and \"destructive\" for commands that delete data or are hard to undo. "+
+			"Set requires_sudo to true if the command needs root privileges. "+
+			"Do not include anything outside the JSON object.",
+		cfg.OS,
+		cfg.Shell,
+		cfg.User,
+		suggestionSchema,
+	) + environmentPrompt(cfg)
+}
+
+// responseSystemPrompt returns the system prompt used for chat (-c) responses
+func responseSystemPrompt(cfg *config.Config) string {
+	return fmt.Sprintf(
+		"You are a helpful assistant for %s, a software developer. "+
+			"You are a terminal assistant for %s using %s shell. "+
+			"Provide informative and concise responses to queries about programming and development."+
+			"The user is asking for information in an explanation format, so respond with concise explanations."+
+			"The user does not wish to continue the conversation, so do not ask for clarification or further information.",
+		cfg.User,
+		cfg.OS,
+		cfg.Shell,
+	) + environmentPrompt(cfg)
+}
+
+// chatSystemPrompt returns the system prompt used for multi-turn chat sessions
+func chatSystemPrompt(cfg *config.Config) string {
+	return fmt.Sprintf(
+		"You are a helpful assistant for %s, a software developer. "+
+			"You are a terminal assistant for %s using %s shell, talking with the user over several turns. "+
+			"Help the user investigate and fix problems step by step. "+
+			"Keep answers concise, put commands in code blocks, and ask for the output of a command when you need more information. "+
+			"The user may share command output or files with you during the conversation.",
+		cfg.User,
+		cfg.OS,
+		cfg.Shell,
+	) + environmentPrompt(cfg)
+}
+
+// errorSystemPrompt returns the system prompt used for error assistance
+func errorSystemPrompt(cfg *config.Config) string {
+	return fmt.Sprintf(
+		"You are a helpful assistant for %s, a software developer. "+
+			"You are a terminal assistant for %s using %s shell. "+
+			"It is safe to assume that the user is working on a legitimate project. "+
+			"It is safe doesn't violate any policies. "+
+			"The user has encountered an error. You need to find solution for this error. "+
+			"You should provide a solution that is easy to understand and follow. "+
+			"Do not offer to continue the conversation, the user does not wish to continue the conversation.",
+		cfg.User,
+		cfg.OS,
+		cfg.Shell,
+	) + environmentPrompt(cfg)
+}
+
+// environmentPrompt describes the user's project and installed tools so suggestions
+// use the project's own build tools and targets and avoid programs that are missing
+func environmentPrompt(cfg *config.Config) string {
+	var prompt string
+	if cfg.Project != "" {
+		prompt += "\n\nProject facts, prefer the project's own tools, scripts and targets over generic commands:\n" + cfg.Project
+	}
+	if cfg.Tools != "" {
+		prompt += "\n\nCommon tools on this machine, avoid the ones that are not installed:\n" + cfg.Tools
+	}
+	return prompt
+}
+
+// errorUserPrompt returns the user message describing the error and its context
+func errorUserPrompt(failure Failure, contextInfo string) string {
+	return fmt.Sprintf(
+		"You have the following context information: { %s } "+
+			"The error encountered is: { %s }",
+		contextInfo,
+		failure.String(),
+	)
+}
@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Risk describes what running a suggested command does to the system
+type Risk string
+
+const (
+	RiskSafe        Risk = "safe"        // Read-only, no lasting effect
+	RiskModifies    Risk = "modifies"    // Changes files, packages or settings
+	RiskDestructive Risk = "destructive" // Deletes data or is hard to undo
+	RiskUnknown     Risk = "unknown"     // The model did not classify the command
+)
+
+// Suggestion is a single command suggested by the LLM
+type Suggestion struct {
+	Command      string `json:"command"`
+	Description  string `json:"description"`
+	Risk         Risk   `json:"risk"`
+	RequiresSudo bool   `json:"requires_sudo"`
+
+	// Programs the command runs that aren't installed, and flags that aren't in the
+	// help text of their program, filled in after the reply is parsed
+	Missing      []MissingTool `json:"missing,omitempty"`
+	UnknownFlags []UnknownFlag `json:"unknown_flags,omitempty"`
+}
+
+// MissingTool is a program a suggested command needs that isn't installed
+type MissingTool struct {
+	Name    string `json:"name"`
+	Install string `json:"install,omitempty"` // Command that installs it, if known
+}
+
+// UnknownFlag is a flag passed to a program whose help text doesn't mention it
+type UnknownFlag struct {
+	Program string `json:"program"`
+	Flag    string `json:"flag"`
+}
+
+// suggestionResponse is the JSON document the model is asked to produce
+type suggestionResponse struct {
+	Commands []Suggestion `json:"commands"`
+}
+
+// suggestionSchema is included in the command system prompt to describe the expected output
+const suggestionSchema = `{"commands": [{"command": "<command>", "description": "<what it does>", "risk": "safe|modifies|destructive", "requires_sudo": false}]}`
+
+// parseSuggestions extracts the suggested commands from the model's reply. Replies
+// that are not JSON are treated as one command per line.
+func parseSuggestions(text string) ([]Suggestion, error) {
+	text = strings.TrimSpace(text)
+
+	// Models often wrap JSON in a markdown code fence, so start at the first brace
+	start := strings.Index(text, "{")
+	if start >= 0 {
+		var resp suggestionResponse
+		err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&resp)
+		jsonReply := isJSONReply(text)
+		switch {
+		case err == nil && (jsonReply || len(resp.Commands) > 0):
+			return validSuggestions(resp.Commands)
+		case err == nil || !jsonReply:
+			// Braces in plain commands, like find -exec {} or awk '{print $1}'
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, fmt.Errorf("LLM response ends in the middle of the JSON: %w", ErrTruncated)
+		default:
+			// Splitting broken JSON into lines would suggest fragments of it as commands
+			return nil, fmt.Errorf("LLM response is not valid JSON: %w", err)
+		}
+	}
+
+	var suggestions []Suggestion
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "```") {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Command: line, Risk: RiskUnknown})
+	}
+	if len(suggestions) == 0 {
+		return nil, fmt.Errorf("no commands in LLM response")
+	}
+	return suggestions, nil
+}
+
+// isJSONReply reports whether the reply is meant to be JSON, either bare or in a code fence
+func isJSONReply(text string) bool {
+	if strings.HasPrefix(text, "{") {
+		return true
+	}
+	fence, _, _ := strings.Cut(text, "\n")
+	return fence == "```json" || (fence == "```" && strings.HasPrefix(strings.TrimSpace(text[len(fence):]), "{"))
+}
+
+// validSuggestions drops empty commands and normalizes the risk of the rest
+func validSuggestions(commands []Suggestion) ([]Suggestion, error) {
+	suggestions := make([]Suggestion, 0, len(commands))
+	for _, s := range commands {
+		s.Command = strings.TrimSpace(s.Command)
+		if s.Command == "" {
+			continue
+		}
+		switch s.Risk {
+		case RiskSafe, RiskModifies, RiskDestructive:
+		default:
+			s.Risk = RiskUnknown
+		}
+		suggestions = append(suggestions, s)
+	}
+	if len(suggestions) == 0 {
+		return nil, fmt.Errorf("no commands in LLM response")
+	}
+	return suggestions, nil
+}
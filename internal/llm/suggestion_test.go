@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSuggestions(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  []string
+	}{
+		{"json", `{"commands": [{"command": "ls -la", "description": "List files", "risk": "safe"}]}`, []string{"ls -la"}},
+		{"fenced json", "```json\n{\"commands\": [{\"command\": \"df -h\"}]}\n```", []string{"df -h"}},
+		{"lines", "ls -la\ndu -sh .", []string{"ls -la", "du -sh ."}},
+		{"braces in commands", "find . -name '*.tmp' -exec rm {} \\;\nawk '{print $1}' log.txt", []string{"find . -name '*.tmp' -exec rm {} \\;", "awk '{print $1}' log.txt"}},
+	}
+	for _, tt := range tests {
+		suggestions, err := parseSuggestions(tt.reply)
+		if err != nil {
+			t.Errorf("%s: parseSuggestions: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, s := range suggestions {
+			got = append(got, s.Command)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: commands = %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: commands = %q, want %q", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseSuggestionsBrokenJSON(t *testing.T) {
+	tests := []struct {
+		reply     string
+		truncated bool
+	}{
+		{`{"commands": [{"command": "du -ah . | sort -rh | head -n 5", "description": "List the`, true},
+		{"```json\n{\"commands\": [{\"command\": \"du -ah .\", \"descr", true},
+		{`{"commands": [{"command": "ls",}]}`, false},
+	}
+	for _, tt := range tests {
+		suggestions, err := parseSuggestions(tt.reply)
+		if err == nil {
+			t.Errorf("parseSuggestions(%q) = %v, want an error", tt.reply, suggestions)
+			continue
+		}
+		if got := errors.Is(err, ErrTruncated); got != tt.truncated {
+			t.Errorf("parseSuggestions(%q) error %v, truncated = %v, want %v", tt.reply, err, got, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+// client implements the kass modes on top of a provider, so every provider
+// gets the same prompts and the same handling of replies
+type client struct {
+	provider Provider
+	config   *config.Config
+}
+
+func (c *client) Complete(ctx context.Context, req Request) (string, error) {
+	return c.provider.Complete(ctx, req)
+}
+
+func (c *client) GetCommand(ctx context.Context, prompt string) ([]Suggestion, error) {
+	text, err := c.complete(ctx, "command", c.config.Timeouts.Command, Request{
+		System:         commandSystemPrompt(c.config),
+		Messages:       []Message{{Role: RoleUser, Content: prompt}},
+		ResponseFormat: FormatJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseSuggestions(text)
+}
+
+func (c *client) GetResponse(ctx context.Context, prompt string) (string, error) {
+	return c.complete(ctx, "response", c.config.Timeouts.Response, Request{
+		System:   responseSystemPrompt(c.config),
+		Messages: []Message{{Role: RoleUser, Content: prompt}},
+	})
+}
+
+func (c *client) HandleError(ctx context.Context, failure Failure, contextInfo string) (string, error) {
+	return c.complete(ctx, "error", c.config.Timeouts.Error, Request{
+		System:   errorSystemPrompt(c.config),
+		Messages: []Message{{Role: RoleUser, Content: errorUserPrompt(failure, contextInfo)}},
+	})
+}
+
+func (c *client) StreamResponse(ctx context.Context, prompt string, w io.Writer) error {
+	_, err := c.complete(ctx, "response", c.config.Timeouts.Response, Request{
+		System:   responseSystemPrompt(c.config),
+		Messages: []Message{{Role: RoleUser, Content: prompt}},
+		Stream:   w,
+	})
+	return err
+}
+
+func (c *client) Chat(ctx context.Context, messages []Message, w io.Writer) (string, error) {
+	return c.complete(ctx, "chat", c.config.Timeouts.Chat, Request{
+		System:   chatSystemPrompt(c.config),
+		Messages: messages,
+		Stream:   w,
+	})
+}
+
+// complete sends a request within the configured timeout of a mode. timeout was
+// validated when the config was loaded; zero means no timeout.
+func (c *client) complete(ctx context.Context, mode string, timeout string, req Request) (string, error) {
+	limit, _ := time.ParseDuration(timeout)
+	if limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	text, err := c.completeWithRetry(ctx, req)
+
+	// Providers report cancellation in their own ways, so it is read from ctx
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return text, fmt.Errorf("no reply within %s, raise timeouts.%s in the config file to wait longer: %w", limit, mode, context.DeadlineExceeded)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return text, fmt.Errorf("request cancelled: %w", context.Canceled)
+	}
+	return text, err
+}
+
+// completeWithRetry sends a request, retrying rate-limited and transient failures with
+// backoff. Streamed replies that were partly written are not retried, since the
+// output can't be taken back.
+func (c *client) completeWithRetry(ctx context.Context, req Request) (string, error) {
+	for attempt := 0; ; attempt++ {
+		text, err := c.provider.Complete(ctx, req)
+		err = classify(c.config.LLM.Provider, err)
+
+		var llmErr *Error
+		if err == nil || !errors.As(err, &llmErr) || !llmErr.Retryable() || attempt >= maxRetries || text != "" {
+			return text, err
+		}
+
+		wait := retryWait(attempt, llmErr.RetryAfter)
+		if wait > maxRetryWait {
+			return text, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return text, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return text, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// frames are drawn in turn while waiting
+var frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const frameInterval = 100 * time.Millisecond
+
+// Spinner shows a label and the elapsed time on stderr while waiting for the LLM.
+// Nothing is shown when stderr isn't a terminal, so scripts and pipes stay clean.
+type Spinner struct {
+	label string
+	start time.Time
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// Start shows a spinner with label, like "Waiting for gemini", until Stop is called
+func Start(label string) *Spinner {
+	s := &Spinner{label: label, start: time.Now(), done: make(chan struct{})}
+	if !readline.IsTerminal(int(os.Stderr.Fd())) {
+		return s
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(frameInterval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			elapsed := time.Since(s.start).Truncate(time.Second)
+			fmt.Fprintf(os.Stderr, "\r%s %s... %s (Ctrl-C to cancel) ", frames[i%len(frames)], s.label, elapsed)
+			select {
+			case <-s.done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// Stop clears the spinner. It is safe to call more than once.
+func (s *Spinner) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+}
+
+// Writer returns a writer that stops the spinner before the first write, for
+// streamed replies that replace the spinner once they start
+func (s *Spinner) Writer(w io.Writer) io.Writer {
+	return &stopWriter{spinner: s, w: w}
+}
+
+type stopWriter struct {
+	spinner *Spinner
+	w       io.Writer
+}
+
+func (sw *stopWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		sw.spinner.Stop()
+	}
+	return sw.w.Write(p)
+}
@@ -0,0 +1,276 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxTargets bounds how many Make targets, scripts or recipes are listed
+const maxTargets = 20
+
+var (
+	goModule       = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	goVersion      = regexp.MustCompile(`(?m)^go\s+(\S+)`)
+	cargoName      = regexp.MustCompile(`(?m)^name\s*=\s*"([^"]+)"`)
+	makeTarget     = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_./-]*)\s*:([^=]|$)`)
+	justRecipe     = regexp.MustCompile(`^@?([A-Za-z0-9][A-Za-z0-9_-]*)(\s+[^:]*)?:([^=]|$)`)
+	composeService = regexp.MustCompile(`^  ([A-Za-z0-9][A-Za-z0-9_.-]*):\s*$`)
+)
+
+// Facts describes the ecosystems and build tools of a project
+type Facts struct {
+	lines []string
+}
+
+// Detect looks for project files in dir, and in its parents up to the repository
+// root, to find out which languages, package managers and task runners are used
+func Detect(dir string) *Facts {
+	d := &detector{dirs: searchDirs(dir)}
+	f := &Facts{}
+	for _, detect := range []func(*detector) string{
+		detectGo,
+		detectNode,
+		detectRust,
+		detectPython,
+		detectMake,
+		detectJust,
+		detectCompose,
+	} {
+		if line := detect(d); line != "" {
+			f.lines = append(f.lines, line)
+		}
+	}
+	return f
+}
+
+// String returns the facts as a compact list for the system prompt, or "" if nothing was found
+func (f *Facts) String() string {
+	if len(f.lines) == 0 {
+		return ""
+	}
+	return "- " + strings.Join(f.lines, "\n- ")
+}
+
+// detector finds project files in the nearest directory that has them
+type detector struct {
+	dirs []string // dir followed by its parents up to the repository root
+}
+
+// find returns the path of the first of names that exists, searching from dir upwards
+func (d *detector) find(names ...string) string {
+	for _, dir := range d.dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// exists reports whether a file exists next to another project file
+func exists(nextTo string, name string) bool {
+	_, err := os.Stat(filepath.Join(filepath.Dir(nextTo), name))
+	return err == nil
+}
+
+func detectGo(d *detector) string {
+	path := d.find("go.mod")
+	if path == "" {
+		return ""
+	}
+	data, _ := os.ReadFile(path)
+
+	desc := "Go module"
+	if m := goModule.FindSubmatch(data); m != nil {
+		desc += " " + string(m[1])
+	}
+	if m := goVersion.FindSubmatch(data); m != nil {
+		desc += fmt.Sprintf(" (go %s)", m[1])
+	}
+	return desc + ": build with go build ./..., test with go test ./..."
+}
+
+func detectNode(d *detector) string {
+	path := d.find("package.json")
+	if path == "" {
+		return ""
+	}
+
+	var pkg struct {
+		Name           string            `json:"name"`
+		PackageManager string            `json:"packageManager"`
+		Scripts        map[string]string `json:"scripts"`
+	}
+	data, _ := os.ReadFile(path)
+	_ = json.Unmarshal(data, &pkg)
+
+	manager := "npm"
+	switch {
+	case pkg.PackageManager != "":
+		manager, _, _ = strings.Cut(pkg.PackageManager, "@")
+	case exists(path, "pnpm-lock.yaml"):
+		manager = "pnpm"
+	case exists(path, "yarn.lock"):
+		manager = "yarn"
+	case exists(path, "bun.lockb"), exists(path, "bun.lock"):
+		manager = "bun"
+	}
+
+	desc := "Node.js package"
+	if pkg.Name != "" {
+		desc += " " + pkg.Name
+	}
+	desc += " managed with " + manager
+
+	scripts := make([]string, 0, len(pkg.Scripts))
+	for name := range pkg.Scripts {
+		scripts = append(scripts, name)
+	}
+	sort.Strings(scripts)
+	if len(scripts) > 0 {
+		desc += fmt.Sprintf("; scripts: %s (run with %s run <script>)", list(scripts), manager)
+	}
+	return desc
+}
+
+func detectRust(d *detector) string {
+	path := d.find("Cargo.toml")
+	if path == "" {
+		return ""
+	}
+	data, _ := os.ReadFile(path)
+
+	desc := "Rust crate"
+	if strings.Contains(string(data), "[workspace]") {
+		desc = "Rust workspace"
+	} else if m := cargoName.FindSubmatch(data); m != nil {
+		desc += " " + string(m[1])
+	}
+	return desc + ": build with cargo build, test with cargo test"
+}
+
+func detectPython(d *detector) string {
+	path := d.find("pyproject.toml", "setup.py", "requirements.txt", "Pipfile")
+	if path == "" {
+		return ""
+	}
+	data, _ := os.ReadFile(filepath.Join(filepath.Dir(path), "pyproject.toml"))
+	pyproject := string(data)
+
+	manager := "pip"
+	switch {
+	case exists(path, "uv.lock") || strings.Contains(pyproject, "[tool.uv"):
+		manager = "uv (use uv run, uv add)"
+	case exists(path, "poetry.lock") || strings.Contains(pyproject, "[tool.poetry"):
+		manager = "poetry (use poetry run, poetry add)"
+	case exists(path, "pdm.lock") || strings.Contains(pyproject, "[tool.pdm"):
+		manager = "pdm"
+	case strings.Contains(pyproject, "[tool.hatch"):
+		manager = "hatch"
+	case exists(path, "Pipfile"):
+		manager = "pipenv"
+	}
+	return "Python project managed with " + manager
+}
+
+func detectMake(d *detector) string {
+	path := d.find("GNUmakefile", "makefile", "Makefile")
+	if path == "" {
+		return ""
+	}
+	targets := scanLines(path, makeTarget)
+	if len(targets) == 0 {
+		return "Makefile present (run with make)"
+	}
+	return fmt.Sprintf("Make targets: %s (run with make <target>)", list(targets))
+}
+
+func detectJust(d *detector) string {
+	path := d.find("justfile", "Justfile", ".justfile")
+	if path == "" {
+		return ""
+	}
+	recipes := scanLines(path, justRecipe)
+	if len(recipes) == 0 {
+		return "Justfile present (run with just)"
+	}
+	return fmt.Sprintf("just recipes: %s (run with just <recipe>)", list(recipes))
+}
+
+func detectCompose(d *detector) string {
+	path := d.find("compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml")
+	if path == "" {
+		return ""
+	}
+
+	// Services are the keys indented by two spaces under the top-level services key
+	var services []string
+	data, _ := os.ReadFile(path)
+	inServices := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "#") {
+			inServices = strings.HasPrefix(line, "services:")
+			continue
+		}
+		if m := composeService.FindStringSubmatch(line); inServices && m != nil {
+			services = append(services, m[1])
+		}
+	}
+
+	desc := "Docker Compose file " + filepath.Base(path)
+	if len(services) > 0 {
+		desc += " with services: " + list(services)
+	}
+	return desc + " (run with docker compose)"
+}
+
+// scanLines returns the distinct first capture group of every line matching re
+func scanLines(path string, re *regexp.Regexp) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		m := re.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil || seen[m[1]] || strings.HasPrefix(m[1], ".") {
+			continue
+		}
+		seen[m[1]] = true
+		names = append(names, m[1])
+	}
+	return names
+}
+
+func list(names []string) string {
+	if len(names) > maxTargets {
+		return strings.Join(names[:maxTargets], ", ") + fmt.Sprintf(" and %d more", len(names)-maxTargets)
+	}
+	return strings.Join(names, ", ")
+}
+
+// searchDirs returns dir and its parents up to the closest one containing .git.
+// Outside a repository only dir itself is searched.
+func searchDirs(dir string) []string {
+	var dirs []string
+	for current := dir; ; {
+		dirs = append(dirs, current)
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return dirs
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return []string{dir}
+		}
+		current = parent
+	}
+}
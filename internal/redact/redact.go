@@ -0,0 +1,268 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+// Redaction records a secret that was removed before sending data to the LLM
+type Redaction struct {
+	Source string // Where the secret was found, e.g. a file path or "shell history"
+	Kind   string // Which detector matched
+	Line   int
+}
+
+func (r Redaction) String() string {
+	return fmt.Sprintf("%s in %s (line %d)", r.Kind, r.Source, r.Line)
+}
+
+// detector finds secrets with a regular expression. If group is set, only that
+// capture group is replaced, so surrounding context like "password=" is kept.
+type detector struct {
+	kind    string
+	re      *regexp.Regexp
+	group   int
+	entropy float64 // Minimum Shannon entropy of the secret, 0 to always redact
+
+	// Matches inside a span of skip are kept, e.g. checksums that look random but aren't secret
+	skip *regexp.Regexp
+
+	// For generic assignments, skip values that look like code (function calls, identifiers)
+	skipCode bool
+
+	// Skip matches made of readable names, like paths and snake_case identifiers
+	skipNames bool
+}
+
+var builtinDetectors = []detector{
+	{kind: "private-key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY( BLOCK)?-----`)},
+	{kind: "aws-access-key", re: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{kind: "aws-secret-key", re: regexp.MustCompile(`(?i)(aws_?secret_?access_?key["']?\s*[:=]\s*["']?)([A-Za-z0-9/+=]{40})`), group: 2},
+	{kind: "gcp-api-key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{kind: "github-token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{kind: "gitlab-token", re: regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`)},
+	{kind: "slack-token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{kind: "stripe-key", re: regexp.MustCompile(`\b[sr]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{kind: "llm-api-key", re: regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_\-]{20,}\b`)},
+	{kind: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{kind: "auth-header", re: regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic|token)\s+)([A-Za-z0-9._~+/=-]+)`), group: 2},
+	{kind: "url-password", re: regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://[^/\s:@]+:)([^/\s@]+)(@)`), group: 2},
+	{kind: "secret-assignment", re: regexp.MustCompile(`(?i)([A-Za-z0-9_.-]*(?:password|passwd|secret|token|api_?key|access_?key|private_?key)[A-Za-z0-9_.-]*["']?\s*[:=]\s*["']?)([^\s"'$(){}<>,;]{8,})`), group: 2, skipCode: true},
+	{kind: "high-entropy-string", re: regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`), entropy: 4.3, skip: checksum, skipNames: true},
+}
+
+// checksum matches hashes in lock files and integrity attributes, like the h1: hashes
+// of go.sum or the sha512- hashes of package-lock.json
+var checksum = regexp.MustCompile(`\b(?:h1:|sha(?:1|256|384|512)-)[A-Za-z0-9+/=]+`)
+
+// envLine matches an assignment in a .env file
+var envLine = regexp.MustCompile(`^(\s*(?:export\s+)?[A-Za-z_][A-Za-z0-9_.]*\s*=\s*)(.+?)\s*$`)
+
+// Redactor replaces secrets in text with placeholders. A nil *Redactor leaves text unchanged.
+type Redactor struct {
+	detectors []detector
+
+	// OnRedact, if set, is called for every secret that is removed
+	OnRedact func(Redaction)
+}
+
+// New creates a redactor with the built-in detectors and the user-defined patterns in cfg.
+// It returns nil if redaction is disabled.
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+
+	r := &Redactor{detectors: append([]detector{}, builtinDetectors...)}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{kind: p.Name, re: re})
+	}
+	return r, nil
+}
+
+// Redact removes secrets from text. source describes where the text came from for the report.
+func (r *Redactor) Redact(source string, text string) string {
+	if r == nil {
+		return text
+	}
+	for _, d := range r.detectors {
+		text = r.apply(source, text, d)
+	}
+	return text
+}
+
+// RedactFile is like Redact, but also removes every value from .env files
+func (r *Redactor) RedactFile(path string, text string) string {
+	if r == nil {
+		return text
+	}
+	if IsEnvFile(path) {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			m := envLine.FindStringSubmatch(line)
+			if m == nil || strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			value := strings.Trim(m[2], `"'`)
+			if value == "" {
+				continue
+			}
+			lines[i] = m[1] + placeholder("env-value")
+			r.record(Redaction{Source: path, Kind: "env-value", Line: i + 1})
+		}
+		text = strings.Join(lines, "\n")
+	}
+	return r.Redact(path, text)
+}
+
+// IsEnvFile reports whether a file holds environment variables, like .env or prod.env.
+// Example files such as .env.example are not treated as env files.
+func IsEnvFile(path string) bool {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, ".env") && !strings.HasSuffix(name, ".env") {
+		return false
+	}
+	for _, suffix := range []string{".example", ".sample", ".template", ".dist"} {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Redactor) apply(source string, text string, d detector) string {
+	matches := d.re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var skipped [][]int
+	if d.skip != nil {
+		skipped = d.skip.FindAllStringIndex(text, -1)
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.group > 0 {
+			start, end = m[2*d.group], m[2*d.group+1]
+		}
+		if start < last || start < 0 {
+			continue
+		}
+		secret := text[start:end]
+		if strings.HasPrefix(secret, "[REDACTED") || (d.entropy > 0 && !looksRandom(secret, d.entropy)) {
+			continue
+		}
+		if d.skipCode && looksLikeCode(secret, text[end:]) {
+			continue
+		}
+		if d.skipNames && looksLikeNames(secret, d.entropy) {
+			continue
+		}
+		if within(start, end, skipped) {
+			continue
+		}
+
+		sb.WriteString(text[last:start])
+		sb.WriteString(placeholder(d.kind))
+		last = end
+		r.record(Redaction{Source: source, Kind: d.kind, Line: strings.Count(text[:start], "\n") + 1})
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// within reports whether the range from start to end lies inside one of spans
+func within(start int, end int, spans [][]int) bool {
+	for _, span := range spans {
+		if start >= span[0] && end <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) record(redaction Redaction) {
+	if r.OnRedact != nil {
+		r.OnRedact(redaction)
+	}
+}
+
+func placeholder(kind string) string {
+	return "[REDACTED:" + kind + "]"
+}
+
+// looksRandom reports whether s has the character mix and entropy of a generated secret
+func looksRandom(s string, minEntropy float64) bool {
+	var lower, upper, digit bool
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	if !lower || !upper || !digit {
+		return false
+	}
+	return shannonEntropy(s) >= minEntropy
+}
+
+// looksLikeCode reports whether an assigned value is a function call or identifier rather than a literal secret
+func looksLikeCode(value string, rest string) bool {
+	if strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "[") {
+		return true
+	}
+	return strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ._") == ""
+}
+
+// looksLikeNames reports whether a string split at "/" and "_" is made of names, like
+// web/static/Logo_2x_Retina, rather than a secret that happens to contain those characters.
+// That is the case when two or more parts don't mix upper case, lower case and digits,
+// or when none of the parts looks random on its own.
+func looksLikeNames(s string, minEntropy float64) bool {
+	parts := strings.FieldsFunc(s, func(c rune) bool { return c == '/' || c == '_' })
+	if len(parts) < 2 {
+		return false
+	}
+	names, random := 0, false
+	for _, part := range parts {
+		if len(part) >= 2 && !looksRandom(part, 0) {
+			names++
+		}
+		// Short parts can't reach the entropy of a whole secret, so the bar is lowered for them
+		if looksRandom(part, min(minEntropy, 0.9*math.Log2(float64(len(part))))) {
+			random = true
+		}
+	}
+	return names >= 2 || !random
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,57 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evesfect/k-assist/internal/config"
+)
+
+func TestHighEntropyFalsePositives(t *testing.T) {
+	r, err := New(config.RedactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []string{
+		"- web/static/img/Logo_2x_DarkMode_Retina.png",
+		"- internal/contextbuilder/testdata/LongFixtureName_WithVersion2AndMore.json",
+		"- /usr/local/lib/python3/site-packages/TensorFlow2Estimator_LegacyV1Compat.py",
+		"func Test_ParseSuggestions_WithFencedJSON2AndTrailingText(t *testing.T)",
+		"github.com/googleapis/gax-go/v2 v2.13.0 h1:yitjD5f7jQHhyDsnhKEBU52NdvvdSeGzlAnDPT0hH1s=",
+		"golang.org/x/sys v0.30.0/go.mod h1:/VUhepiaJMQUp4+oa/7Zr1D23ma6VTLIYjOOTFZPUcA=",
+		`"integrity": "sha512-Ap8dpG37kYETA1GKaXmGmRvSnjZ/I6vLkR5lnS7PHUSx/mq6y5ZotB6T2oO4B5PvhJ8vKUV1WfOGPMLqz/VUnA=="`,
+	}
+	for _, text := range tests {
+		if got := r.Redact("test", text); got != text {
+			t.Errorf("Redact(%q) = %q, want it unchanged", text, got)
+		}
+	}
+}
+
+func TestHighEntropyTruePositives(t *testing.T) {
+	r, err := New(config.RedactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text   string
+		secret string
+	}{
+		{"curl -H 'X-Api: q8Vt3LmZ0rXwK7pYbN2sJfH5dGcA9eRuT4yWiO1k' https://example.com", "q8Vt3LmZ0rXwK7pYbN2sJfH5dGcA9eRuT4yWiO1k"},
+		{"WEBHOOK=hooks/Tz4Kq9Lm2Xv7Bn5Rc8Wp3Ys6Hd1Jf0Ga+Ue/x", "Tz4Kq9Lm2Xv7Bn5Rc8Wp3Ys6Hd1Jf0Ga+Ue"},
+		{"curl -H 'X-Api-Key: q8Vt3LmZ0rXwK7pY/bN2sJfH5dGcA9eRuT4yWiO1k' https://example.com", "q8Vt3LmZ0rXwK7pY/bN2sJfH5dGcA9eRuT4yWiO1k"},
+		{"curl -H 'X-Api-Key: q8Vt3LmZ0rXwK7pY_bN2sJfH5dGcA9eRuT4yWiO1k' https://example.com", "q8Vt3LmZ0rXwK7pY_bN2sJfH5dGcA9eRuT4yWiO1k"},
+		{"key: Zm9vYmFyQmF6/Q3x9Lk2Wq7Rt/Yp4Hn8Jd3Vc6Bs+Mk1Xa0=", "Q3x9Lk2Wq7Rt/Yp4Hn8Jd3Vc6Bs+Mk1Xa0"},
+	}
+	for _, tt := range tests {
+		got := r.Redact("test", tt.text)
+		if strings.Contains(got, tt.secret) {
+			t.Errorf("Redact(%q) = %q, want the secret removed", tt.text, got)
+		}
+		if !strings.Contains(got, "[REDACTED:high-entropy-string]") {
+			t.Errorf("Redact(%q) = %q, want a high-entropy-string placeholder", tt.text, got)
+		}
+	}
+}
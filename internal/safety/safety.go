@@ -0,0 +1,384 @@
+package safety
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/evesfect/k-assist/internal/config"
+	"github.com/evesfect/k-assist/internal/shellcmd"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Finding describes why a command was flagged as dangerous
+type Finding struct {
+	Rule    string
+	Message string
+}
+
+// rule is a built-in check run against every node of the parsed command
+type rule struct {
+	name  string
+	check func(node syntax.Node) string // Returns a message if the node is dangerous
+}
+
+var builtinRules = []rule{
+	{name: "rm-recursive", check: checkRecursiveRemove},
+	{name: "disk-write", check: checkDiskWrite},
+	{name: "chmod-recursive", check: checkRecursivePermissions},
+	{name: "pipe-to-shell", check: checkPipeToShell},
+	{name: "git-force-push", check: checkForcePush},
+	{name: "system-config-write", check: checkSystemConfigWrite},
+}
+
+// Analyze checks a command against the built-in rules and the user-defined rules in cfg
+func Analyze(command string, cfg config.SafetyConfig) ([]Finding, error) {
+	var findings []Finding
+
+	for _, custom := range cfg.Rules {
+		re, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid safety rule %q: %w", custom.Name, err)
+		}
+		if re.MatchString(command) {
+			message := custom.Message
+			if message == "" {
+				message = fmt.Sprintf("matches pattern %q", custom.Pattern)
+			}
+			findings = append(findings, Finding{Rule: custom.Name, Message: message})
+		}
+	}
+
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		// Commands for other shells (e.g. powershell) can't be parsed, so only the custom rules apply
+		return findings, nil
+	}
+
+	disabled := make(map[string]bool)
+	for _, name := range cfg.DisabledRules {
+		disabled[name] = true
+	}
+
+	seen := make(map[Finding]bool)
+	var walk func(node syntax.Node) bool
+	walk = func(node syntax.Node) bool {
+		for _, r := range builtinRules {
+			if disabled[r.name] {
+				continue
+			}
+			if message := r.check(node); message != "" {
+				finding := Finding{Rule: r.name, Message: message}
+				if !seen[finding] {
+					seen[finding] = true
+					findings = append(findings, finding)
+				}
+			}
+		}
+		// Scripts run with sh -c are checked like the command itself
+		if script, ok := shellScript(node); ok {
+			if inner, err := syntax.NewParser().Parse(strings.NewReader(script), ""); err == nil {
+				syntax.Walk(inner, walk)
+			}
+		}
+		return true
+	}
+	syntax.Walk(file, walk)
+
+	return findings, nil
+}
+
+// callArgs returns the arguments of a call as strings, with wrappers like sudo removed
+func callArgs(node syntax.Node) []string {
+	call, ok := node.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return nil
+	}
+
+	args := make([]string, len(call.Args))
+	for i, word := range call.Args {
+		args[i] = wordString(word)
+	}
+
+	args = shellcmd.Unwrap(args)
+	if len(args) == 0 {
+		return nil
+	}
+	args[0] = path.Base(args[0])
+	return args
+}
+
+// shellScript returns the script a call like bash -c 'rm -rf /' runs
+func shellScript(node syntax.Node) (string, bool) {
+	args := callArgs(node)
+	if len(args) == 0 || !shells[args[0]] {
+		return "", false
+	}
+	for i, arg := range args[1:] {
+		// -c may be combined with other flags, like bash -ec
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg[1:], "c") {
+			if ops := operands(args[i+2:]); len(ops) > 0 {
+				return ops[0], true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// wordString returns a word's text with quotes removed and expansions left unexpanded
+func wordString(word *syntax.Word) string {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		writeWordPart(&sb, part)
+	}
+	return sb.String()
+}
+
+func writeWordPart(sb *strings.Builder, part syntax.WordPart) {
+	switch p := part.(type) {
+	case *syntax.Lit:
+		sb.WriteString(p.Value)
+	case *syntax.SglQuoted:
+		sb.WriteString(p.Value)
+	case *syntax.DblQuoted:
+		for _, inner := range p.Parts {
+			writeWordPart(sb, inner)
+		}
+	default:
+		syntax.NewPrinter().Print(sb, part)
+	}
+}
+
+// hasFlag reports whether args contain any of the short flags (possibly combined, like -rf) or long flags
+func hasFlag(args []string, short string, long ...string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			for _, l := range long {
+				if arg == l {
+					return true
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "-") && strings.ContainsAny(arg[1:], short) {
+			return true
+		}
+	}
+	return false
+}
+
+// operands returns the arguments that are not flags
+func operands(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// isBroadPath reports whether a path covers the root, a home directory, a top-level system directory or everything in the current directory
+func isBroadPath(p string) bool {
+	p = strings.TrimRight(p, "/")
+	switch p {
+	case "", "~", "$HOME", "${HOME}", ".", "..", "*", ".*", "/*", "~/*", "$HOME/*", "${HOME}/*":
+		return true
+	}
+	if strings.HasPrefix(p, "~") && !strings.Contains(p, "/") {
+		// Another user's home directory, like ~alice
+		return true
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" && p == strings.TrimRight(home, "/") {
+		return true
+	}
+	if strings.HasPrefix(p, "/") {
+		// Top-level directories like /usr, and home directories like /home/alice
+		switch strings.Count(p, "/") {
+		case 1:
+			return true
+		case 2:
+			return strings.HasPrefix(p, "/home/") || strings.HasPrefix(p, "/Users/")
+		}
+	}
+	return false
+}
+
+func checkRecursiveRemove(node syntax.Node) string {
+	args := callArgs(node)
+	if len(args) == 0 || args[0] != "rm" || !hasFlag(args[1:], "rR", "--recursive") {
+		return ""
+	}
+	for _, target := range operands(args[1:]) {
+		if isBroadPath(target) {
+			return fmt.Sprintf("recursively deletes %s", target)
+		}
+	}
+	return ""
+}
+
+func checkDiskWrite(node syntax.Node) string {
+	args := callArgs(node)
+	if len(args) == 0 {
+		return ""
+	}
+	switch {
+	case args[0] == "dd":
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "of=/dev/") {
+				return fmt.Sprintf("writes raw data to %s", strings.TrimPrefix(arg, "of="))
+			}
+		}
+	case args[0] == "mkfs" || strings.HasPrefix(args[0], "mkfs."):
+		return "creates a filesystem, erasing the target device"
+	case args[0] == "wipefs" || args[0] == "fdisk" || args[0] == "sfdisk" || args[0] == "parted":
+		return fmt.Sprintf("%s modifies disk partitions or signatures", args[0])
+	}
+	return ""
+}
+
+func checkRecursivePermissions(node syntax.Node) string {
+	args := callArgs(node)
+	if len(args) == 0 || !hasFlag(args[1:], "R", "--recursive") {
+		return ""
+	}
+	ops := operands(args[1:])
+	switch args[0] {
+	case "chmod":
+		for _, op := range ops {
+			if op == "777" || op == "0777" || op == "a+rwx" || op == "ugo+rwx" {
+				return fmt.Sprintf("recursively makes files world-writable (%s)", op)
+			}
+		}
+		fallthrough
+	case "chown", "chgrp":
+		if len(ops) < 2 {
+			return ""
+		}
+		// The first operand is the mode or owner
+		for _, op := range ops[1:] {
+			if isBroadPath(op) {
+				return fmt.Sprintf("recursively changes ownership or permissions of %s", op)
+			}
+		}
+	}
+	return ""
+}
+
+var shells = map[string]bool{"sh": true, "bash": true, "zsh": true, "fish": true, "dash": true, "ksh": true}
+
+var downloaders = map[string]bool{"curl": true, "wget": true, "fetch": true}
+
+// containsDownload reports whether a node contains a call to a downloader
+func containsDownload(node syntax.Node) bool {
+	found := false
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if args := callArgs(n); len(args) > 0 && downloaders[args[0]] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func checkPipeToShell(node syntax.Node) string {
+	switch n := node.(type) {
+	case *syntax.BinaryCmd:
+		// curl ... | sh
+		if n.Op != syntax.Pipe && n.Op != syntax.PipeAll {
+			return ""
+		}
+		if args := callArgs(n.Y.Cmd); len(args) > 0 && shells[args[0]] && containsDownload(n.X) {
+			return fmt.Sprintf("pipes a downloaded script into %s", args[0])
+		}
+	case *syntax.CallExpr:
+		// sh -c "$(curl ...)" or bash <(curl ...)
+		if args := callArgs(n); len(args) > 0 && shells[args[0]] {
+			for _, word := range n.Args[1:] {
+				if containsDownload(word) {
+					return fmt.Sprintf("runs a downloaded script with %s", args[0])
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func checkForcePush(node syntax.Node) string {
+	args := callArgs(node)
+	if len(args) < 2 || args[0] != "git" {
+		return ""
+	}
+	// Skip global options like -C <dir>
+	rest := args[1:]
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		if rest[0] == "-C" || rest[0] == "-c" {
+			rest = rest[1:]
+		}
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || rest[0] != "push" {
+		return ""
+	}
+	if hasFlag(rest[1:], "f", "--force", "--mirror") {
+		return "force pushes, which can overwrite remote history"
+	}
+	for _, op := range operands(rest[1:]) {
+		if strings.HasPrefix(op, "+") {
+			return fmt.Sprintf("force pushes %s, which can overwrite remote history", strings.TrimPrefix(op, "+"))
+		}
+	}
+	return ""
+}
+
+func checkSystemConfigWrite(node syntax.Node) string {
+	switch n := node.(type) {
+	case *syntax.Redirect:
+		switch n.Op {
+		case syntax.RdrOut, syntax.AppOut, syntax.ClbOut, syntax.RdrAll, syntax.AppAll:
+			if target := wordString(n.Word); isSystemPath(target) {
+				return fmt.Sprintf("writes to %s", target)
+			}
+		}
+	case *syntax.CallExpr:
+		args := callArgs(n)
+		if len(args) == 0 {
+			return ""
+		}
+		switch args[0] {
+		case "tee", "cp", "mv", "install", "ln":
+			ops := operands(args[1:])
+			if args[0] != "tee" && len(ops) > 0 {
+				// Only the destination matters when copying or moving
+				ops = ops[len(ops)-1:]
+			}
+			for _, op := range ops {
+				if isSystemPath(op) {
+					return fmt.Sprintf("writes to %s", op)
+				}
+			}
+		case "sed":
+			if hasFlag(args[1:], "i", "--in-place") {
+				for _, op := range operands(args[1:]) {
+					if isSystemPath(op) {
+						return fmt.Sprintf("edits %s in place", op)
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// isSystemPath reports whether a path is under a system configuration or boot directory
+func isSystemPath(p string) bool {
+	for _, dir := range []string{"/etc", "/boot", "/usr/lib/systemd"} {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package shell
+
+import "fmt"
+
+// Shell integration scripts printed by `kass init <shell>`. Each one binds Ctrl-G to
+// replace the current command line with kass's suggestion for it, so the command is
+// edited and run by the user's own shell.
+
+const bashInitScript = `# kass shell integration for bash
+# Add to ~/.bashrc: eval "$(kass init bash)"
+_kass_suggest() {
+    [ -n "$READLINE_LINE" ] || return
+    local suggestion
+    suggestion="$(command kass -n -- "$READLINE_LINE")" || return
+    [ -n "$suggestion" ] || return
+    READLINE_LINE="$suggestion"
+    READLINE_POINT=${#READLINE_LINE}
+}
+bind -x '"\C-g": _kass_suggest'
+`
+
+const zshInitScript = `# kass shell integration for zsh
+# Add to ~/.zshrc: eval "$(kass init zsh)"
+_kass_suggest() {
+    [[ -n "$BUFFER" ]] || return
+    local suggestion
+    zle -I
+    suggestion="$(command kass -n -- "$BUFFER")"
+    if [[ $? -eq 0 && -n "$suggestion" ]]; then
+        BUFFER="$suggestion"
+        CURSOR=${#BUFFER}
+    fi
+    zle reset-prompt
+}
+zle -N _kass_suggest
+bindkey '^G' _kass_suggest
+`
+
+const fishInitScript = `# kass shell integration for fish
+# Add to ~/.config/fish/config.fish: kass init fish | source
+function _kass_suggest
+    set -l line (commandline)
+    test -n "$line"; or return
+    set -l suggestion (command kass -n -- "$line" | string collect)
+    and commandline -r -- $suggestion
+    commandline -f repaint
+end
+bind \cg _kass_suggest
+bind -M insert \cg _kass_suggest
+`
+
+// InitScript returns the integration script for the given shell
+func InitScript(shellType string) (string, error) {
+	switch shellType {
+	case "bash":
+		return bashInitScript, nil
+	case "zsh":
+		return zshInitScript, nil
+	case "fish":
+		return fishInitScript, nil
+	default:
+		return "", fmt.Errorf("unsupported shell for init: %s (expected bash, zsh or fish)", shellType)
+	}
+}
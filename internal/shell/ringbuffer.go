@@ -0,0 +1,64 @@
+package shell
+
+import (
+	"bytes"
+	"sync"
+)
+
+// outputTailSize is how much of a command's stdout and stderr is kept for error assistance
+const outputTailSize = 4096
+
+// ringBuffer is an io.Writer that keeps only the last size bytes written to it
+type ringBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	size      int
+	start     int // Index of the oldest byte once the buffer is full
+	truncated bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, 0, size), size: size}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	if len(p) > r.size {
+		p = p[len(p)-r.size:]
+		r.truncated = true
+	}
+
+	// Fill up the buffer before wrapping around
+	if free := r.size - len(r.buf); free > 0 {
+		fill := min(free, len(p))
+		r.buf = append(r.buf, p[:fill]...)
+		p = p[fill:]
+	}
+	for len(p) > 0 {
+		copied := copy(r.buf[r.start:], p)
+		p = p[copied:]
+		r.start = (r.start + copied) % r.size
+		r.truncated = true
+	}
+
+	return n, nil
+}
+
+// String returns the buffered bytes in order. If earlier output was dropped,
+// the partial first line is removed and the result is marked as truncated.
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(append([]byte{}, r.buf[r.start:]...), r.buf[:r.start]...)
+	if !r.truncated {
+		return string(data)
+	}
+	if idx := bytes.IndexByte(data, '\n'); idx >= 0 && idx < len(data)-1 {
+		data = data[idx+1:]
+	}
+	return "[... earlier output truncated ...]\n" + string(data)
+}
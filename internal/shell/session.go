@@ -0,0 +1,290 @@
+package shell
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Session is a long-lived shell process that runs commands one after another, so the
+// working directory, environment variables and shell state carry over between steps.
+//
+// Commands run on the real terminal, so pagers, editors and colors work as usual. Output
+// is only sent through a pipe when it is captured. After each command the shell prints a
+// marker line with the exit status and working directory on fd 3, and marker lines on
+// the capture pipes, which tell the session that the command has finished.
+type Session struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	marker  string
+	ttyPath string
+	status  *markerScanner // fd 3
+	stdout  *markerScanner // fd 4, copied to stdout
+	stderr  *markerScanner // fd 5, copied to stderr
+}
+
+// Result describes a finished command
+type Result struct {
+	ExitCode int
+	Dir      string // Working directory of the session after the command
+}
+
+// NewSession starts a shell of the given type in dir
+func NewSession(shellType string, dir string) (*Session, error) {
+	var cmd *exec.Cmd
+	switch shellType {
+	case "bash":
+		cmd = exec.Command("bash", "--noprofile", "--norc")
+	case "zsh":
+		cmd = exec.Command("zsh", "-f")
+	default:
+		cmd = exec.Command("sh")
+	}
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating shell stdin: %w", err)
+	}
+
+	// fd 3 reports the status, fd 4 and 5 carry captured stdout and stderr
+	var readers, writers []*os.File
+	closeAll := func(files []*os.File) {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for range 3 {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll(readers)
+			closeAll(writers)
+			return nil, fmt.Errorf("error creating shell pipe: %w", err)
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+	cmd.ExtraFiles = writers
+
+	marker, err := newMarker()
+	if err != nil {
+		closeAll(readers)
+		closeAll(writers)
+		return nil, err
+	}
+
+	err = cmd.Start()
+	// The shell has its own copies of the write ends
+	closeAll(writers)
+	if err != nil {
+		closeAll(readers)
+		return nil, fmt.Errorf("error starting %s: %w", cmd.Path, err)
+	}
+
+	// Commands read from the terminal rather than the pipe the session is driven through
+	ttyPath := "/dev/tty"
+	if tty, err := os.Open(ttyPath); err == nil {
+		tty.Close()
+	} else {
+		ttyPath = "/dev/null"
+	}
+
+	s := &Session{
+		cmd:     cmd,
+		stdin:   stdin,
+		marker:  marker,
+		ttyPath: ttyPath,
+		status:  newMarkerScanner(readers[0], io.Discard, marker),
+		stdout:  newMarkerScanner(readers[1], os.Stdout, marker),
+		stderr:  newMarkerScanner(readers[2], os.Stderr, marker),
+	}
+
+	// Keep the shell alive when ^C interrupts a command
+	if _, err := io.WriteString(stdin, "trap ':' INT\n"); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing shell: %w", err)
+	}
+
+	return s, nil
+}
+
+func newMarker() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating session marker: %w", err)
+	}
+	return "__KASS_" + hex.EncodeToString(b) + "__", nil
+}
+
+// Run executes a command in the session and waits for it to finish. The command's
+// output goes to the terminal. When stdout or stderr is set, that stream is also copied
+// to it, and the command sees a pipe instead of the terminal there.
+func (s *Session) Run(command string, stdout io.Writer, stderr io.Writer) (Result, error) {
+	// ^C is meant for the running command, not for kass
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	s.stdout.setCapture(stdout)
+	defer s.stdout.setCapture(nil)
+	s.stderr.setCapture(stderr)
+	defer s.stderr.setCapture(nil)
+
+	redirects := ""
+	if stdout != nil {
+		redirects += " >&4"
+	}
+	if stderr != nil {
+		redirects += " 2>&5"
+	}
+
+	// The session's own descriptors are closed for the command, so programs it leaves
+	// running in the background don't hold them open
+	script := fmt.Sprintf(
+		"eval '%s' < %s%s 3>&- 4>&- 5>&-\n"+
+			"__kass_status=$?\n"+
+			"printf '\\n%%s\\n' '%s' >&4\n"+
+			"printf '\\n%%s\\n' '%s' >&5\n"+
+			"printf '\\n%%s %%d %%s\\n' '%s' \"$__kass_status\" \"$PWD\" >&3\n",
+		strings.ReplaceAll(command, "'", "'\\''"),
+		s.ttyPath,
+		redirects,
+		s.marker,
+		s.marker,
+		s.marker,
+	)
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		return Result{}, fmt.Errorf("shell session ended: %w", err)
+	}
+
+	// Wait until the captured output has been copied and the status is known
+	if _, ok := <-s.stdout.markers; !ok {
+		return Result{}, fmt.Errorf("shell session ended")
+	}
+	if _, ok := <-s.stderr.markers; !ok {
+		return Result{}, fmt.Errorf("shell session ended")
+	}
+	status, ok := <-s.status.markers
+	if !ok {
+		return Result{}, fmt.Errorf("shell session ended")
+	}
+
+	// The status marker line is "<exit code> <working directory>"
+	fields := strings.SplitN(status, " ", 2)
+	if len(fields) != 2 {
+		return Result{}, fmt.Errorf("malformed status from shell session: %q", status)
+	}
+	exitCode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Result{}, fmt.Errorf("malformed exit code from shell session: %q", fields[0])
+	}
+
+	return Result{ExitCode: exitCode, Dir: fields[1]}, nil
+}
+
+// Close ends the shell process
+func (s *Session) Close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// markerScanner copies a stream to its destination until it sees a marker line,
+// then reports the rest of that line on markers. markers is closed when the stream ends.
+type markerScanner struct {
+	dst     io.Writer
+	token   []byte
+	markers chan string
+
+	mu      sync.Mutex
+	capture io.Writer // Optional extra destination for the current command
+}
+
+func newMarkerScanner(src io.ReadCloser, dst io.Writer, marker string) *markerScanner {
+	m := &markerScanner{
+		dst:     dst,
+		token:   []byte("\n" + marker),
+		markers: make(chan string),
+	}
+	go m.scan(src)
+	return m
+}
+
+func (m *markerScanner) scan(src io.ReadCloser) {
+	defer close(m.markers)
+	defer src.Close()
+
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		pending = append(pending, buf[:n]...)
+
+		for {
+			idx := bytes.Index(pending, m.token)
+			if idx < 0 {
+				break
+			}
+			end := bytes.IndexByte(pending[idx+len(m.token):], '\n')
+			if end < 0 {
+				// Wait for the rest of the marker line
+				break
+			}
+			m.write(pending[:idx])
+			line := pending[idx+len(m.token) : idx+len(m.token)+end]
+			pending = pending[idx+len(m.token)+end+1:]
+			m.markers <- strings.TrimSpace(string(line))
+		}
+
+		// Pass everything through except a possible start of the marker
+		keep := partialPrefix(pending, m.token)
+		if idx := bytes.Index(pending, m.token); idx >= 0 {
+			keep = len(pending) - idx
+		}
+		m.write(pending[:len(pending)-keep])
+		pending = pending[len(pending)-keep:]
+
+		if err != nil {
+			m.write(pending)
+			return
+		}
+	}
+}
+
+func (m *markerScanner) setCapture(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.capture = w
+}
+
+func (m *markerScanner) write(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	m.dst.Write(p)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.capture != nil {
+		m.capture.Write(p)
+	}
+}
+
+// partialPrefix returns the length of the longest suffix of data that is a prefix of token
+func partialPrefix(data []byte, token []byte) int {
+	for n := min(len(token)-1, len(data)); n > 0; n-- {
+		if bytes.HasSuffix(data, token[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -1,18 +1,21 @@
 package shell
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/evesfect/k-assist/internal/config"
 	"github.com/evesfect/k-assist/internal/llm"
+	"github.com/evesfect/k-assist/internal/safety"
 )
 
 type Handler struct {
@@ -20,15 +23,13 @@ type Handler struct {
 	logger      *log.Logger
 	llmClient   llm.Client
 	config      *config.Config
-	handleError func(*log.Logger, llm.Client, *config.Config, string)
+	handleError func(*log.Logger, llm.Client, *config.Config, llm.Failure)
+	session     *Session // Persistent shell used while a plan is running, nil for shells that don't support it
 }
 
-func NewHandler(shellType string, logger *log.Logger, llmClient llm.Client, cfg *config.Config, handleError func(*log.Logger, llm.Client, *config.Config, string)) *Handler {
-	if shellType == "" {
-		shellType = detectShell()
-	}
+func NewHandler(shellType string, logger *log.Logger, llmClient llm.Client, cfg *config.Config, handleError func(*log.Logger, llm.Client, *config.Config, llm.Failure)) *Handler {
 	return &Handler{
-		shellType:   shellType,
+		shellType:   ResolveShell(shellType),
 		logger:      logger,
 		llmClient:   llmClient,
 		config:      cfg,
@@ -36,6 +37,14 @@ func NewHandler(shellType string, logger *log.Logger, llmClient llm.Client, cfg
 	}
 }
 
+// ResolveShell returns the configured shell type, or the user's shell if none is configured
+func ResolveShell(shellType string) string {
+	if shellType == "" {
+		return detectShell()
+	}
+	return shellType
+}
+
 func detectShell() string {
 	if runtime.GOOS == "windows" {
 		return "powershell"
@@ -58,41 +67,76 @@ func (h *Handler) FormatCommand(command string) string {
 	}
 }
 
-func (h *Handler) OutputCommand(commands string) error {
+func (h *Handler) OutputCommand(suggestions []llm.Suggestion) error {
 	rl, err := readline.New("")
 	if err != nil {
 		return fmt.Errorf("error creating readline instance: %w", err)
 	}
 	defer rl.Close()
 
-	// Split commands by newline
-	cmdList := strings.Split(strings.TrimSpace(commands), "\n")
-
 	// Keep track of the current working directory
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("error getting current directory: %w", err)
 	}
 
-	for _, cmd := range cmdList {
-		// Set the prompt with PS1-like style
-		rl.SetPrompt(fmt.Sprintf("%s $ ", currentDir))
+	// Unix shells run the whole plan in one session so cd, exports and venvs carry over
+	if h.supportsSession() {
+		session, err := NewSession(h.shellType, currentDir)
+		if err != nil {
+			return fmt.Errorf("error starting shell session: %w", err)
+		}
+		h.session = session
+		defer func() {
+			session.Close()
+			h.session = nil
+		}()
+	}
 
-		// Pre-populate the line with the suggested command
-		rl.WriteStdin([]byte(cmd))
+	for _, suggestion := range suggestions {
+		if description := describeSuggestion(suggestion); description != "" {
+			fmt.Println(description)
+		}
 
-		// Get user input (they can edit or just press enter)
-		command, err := rl.Readline()
-		if err != nil {
-			return fmt.Errorf("error reading line: %w", err)
+		var command string
+		if strings.Contains(suggestion.Command, "\n") {
+			// Multi-line commands (e.g. heredocs) can't be edited on a single readline, so confirm them instead
+			fmt.Println(suggestion.Command)
+			rl.SetPrompt("Run this command? [Y/n] ")
+			answer, err := rl.Readline()
+			if err != nil {
+				return fmt.Errorf("error reading line: %w", err)
+			}
+			if answer = strings.TrimSpace(answer); answer != "" && answer != "Y" && answer != "y" {
+				continue
+			}
+			command = suggestion.Command
+		} else {
+			// Set the prompt with PS1-like style
+			rl.SetPrompt(fmt.Sprintf("%s $ ", currentDir))
+
+			// Pre-populate the line with the suggested command
+			rl.WriteStdin([]byte(suggestion.Command))
+
+			// Get user input (they can edit or just press enter)
+			command, err = rl.Readline()
+			if err != nil {
+				return fmt.Errorf("error reading line: %w", err)
+			}
 		}
 
 		// Execute the command (original or modified)
 		if command = strings.TrimSpace(command); command != "" {
+			if !h.confirmIfDangerous(rl, command) {
+				continue
+			}
+
 			newDir, err := h.executeCommand(command, currentDir)
 			if err != nil {
 				h.logger.Printf("Error executing command: %v\n", err)
-				h.handleError(h.logger, h.llmClient, h.config, err.Error())
+				failure := &llm.Failure{Message: err.Error(), Command: command, Dir: currentDir}
+				errors.As(err, &failure)
+				h.handleError(h.logger, h.llmClient, h.config, *failure)
 				return nil
 			}
 			currentDir = newDir
@@ -102,7 +146,102 @@ func (h *Handler) OutputCommand(commands string) error {
 	return nil
 }
 
+// confirmIfDangerous checks a command with the configured safety rules
+func (h *Handler) confirmIfDangerous(rl *readline.Instance, command string) bool {
+	return ConfirmIfDangerous(rl, h.logger, h.config.Safety, command)
+}
+
+// ConfirmIfDangerous runs the safety analyzer on a command and, if it is flagged,
+// asks the user to type "yes" before it is run
+func ConfirmIfDangerous(rl *readline.Instance, logger *log.Logger, cfg config.SafetyConfig, command string) bool {
+	findings, err := safety.Analyze(command, cfg)
+	if err != nil {
+		logger.Printf("Refusing to run command: %v", err)
+		return false
+	}
+	if len(findings) == 0 {
+		return true
+	}
+
+	fmt.Println("Warning: this command is potentially destructive:")
+	for _, finding := range findings {
+		fmt.Printf("  - %s (%s)\n", finding.Message, finding.Rule)
+	}
+
+	rl.SetPrompt("Type 'yes' to run it anyway: ")
+	answer, err := rl.Readline()
+	if err != nil || strings.TrimSpace(answer) != "yes" {
+		fmt.Println("Skipped.")
+		return false
+	}
+	return true
+}
+
+// describeSuggestion formats the explanation shown above a suggested command
+func describeSuggestion(suggestion llm.Suggestion) string {
+	var tags []string
+	if suggestion.Risk != llm.RiskUnknown {
+		tags = append(tags, string(suggestion.Risk))
+	}
+	if suggestion.RequiresSudo {
+		tags = append(tags, "requires sudo")
+	}
+
+	description := suggestion.Description
+	if len(tags) > 0 {
+		description = strings.TrimSpace(fmt.Sprintf("%s [%s]", description, strings.Join(tags, ", ")))
+	}
+
+	var lines []string
+	if description != "" {
+		lines = append(lines, "# "+description)
+	}
+	for _, tool := range suggestion.Missing {
+		if tool.Install != "" {
+			lines = append(lines, fmt.Sprintf("# %s is not installed, install it with: %s", tool.Name, tool.Install))
+		} else {
+			lines = append(lines, fmt.Sprintf("# %s is not installed", tool.Name))
+		}
+	}
+	for _, flag := range suggestion.UnknownFlags {
+		lines = append(lines, fmt.Sprintf("# %s is not in the help text of %s, check it before running", flag.Flag, flag.Program))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *Handler) supportsSession() bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	return h.shellType != "powershell" && h.shellType != "cmd"
+}
+
+// executeCommand runs a command and returns the new working directory.
+// If the command fails, the error is an *llm.Failure describing what happened.
 func (h *Handler) executeCommand(command, workDir string) (string, error) {
+	// Only stderr is kept for error assistance. Stdout stays on the terminal, so
+	// pagers, editors and colored output work as they do in the user's shell.
+	stderr := newRingBuffer(outputTailSize)
+	start := time.Now()
+
+	if h.session != nil {
+		result, err := h.session.Run(command, nil, stderr)
+		if err != nil {
+			return workDir, err
+		}
+		if result.ExitCode != 0 {
+			return result.Dir, &llm.Failure{
+				Message:  fmt.Sprintf("exit status %d", result.ExitCode),
+				Command:  command,
+				Dir:      workDir,
+				ExitCode: result.ExitCode,
+				Stderr:   stderr.String(),
+				Duration: time.Since(start),
+			}
+		}
+		return result.Dir, nil
+	}
+
 	var cmd *exec.Cmd
 
 	switch h.shellType {
@@ -116,19 +255,25 @@ func (h *Handler) executeCommand(command, workDir string) (string, error) {
 
 	cmd.Dir = workDir
 
-	// Create pipes for stdout and stderr
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Show output as usual while keeping the tail of stderr for error assistance
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
 
-	err := cmd.Run()
-	if err != nil {
-		// If there's stderr output, include it in the error
-		if stderr.Len() > 0 {
-			return workDir, fmt.Errorf("%v: %s", err, stderr.String())
+	if err := cmd.Run(); err != nil {
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		return workDir, &llm.Failure{
+			Message:  err.Error(),
+			Command:  command,
+			Dir:      workDir,
+			ExitCode: exitCode,
+			Stderr:   stderr.String(),
+			Duration: time.Since(start),
 		}
-		return workDir, err
 	}
 
 	// Check if the command was a cd command
@@ -0,0 +1,68 @@
+package shellcmd
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// wrappers run the command that follows them, after their own options. Each lists
+// the options that take a value as the next argument, like sudo -u root.
+var wrappers = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-h", "-p", "-D", "-R", "-T", "-U", "-r", "-t", "--user", "--group", "--prompt", "--chdir"},
+	"doas":    {"-u", "-C"},
+	"nohup":   nil,
+	"time":    {"-f", "-o", "--format", "--output"},
+	"command": nil,
+	"exec":    {"-a"},
+	"env":     {"-u", "-C", "-S", "--unset", "--chdir", "--split-string"},
+	"nice":    {"-n", "--adjustment"},
+	"xargs":   {"-n", "-I", "-i", "-P", "-L", "-l", "-s", "-d", "-E", "-e", "-a", "--max-args", "--max-procs", "--max-lines", "--delimiter", "--arg-file"},
+	"watch":   {"-n", "--interval"},
+}
+
+// IsWrapper reports whether a program, given by name or path, runs the command that
+// follows it, like sudo or xargs
+func IsWrapper(name string) bool {
+	_, ok := wrappers[path.Base(name)]
+	return ok
+}
+
+// SkipWrapper removes a wrapper, its options and environment assignments from the
+// front of args, leaving the wrapped command
+func SkipWrapper(args []string) []string {
+	valueOptions := wrappers[path.Base(args[0])]
+	args = args[1:]
+	for len(args) > 0 && (strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "=")) {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		if takesValue(option, valueOptions) && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	return args
+}
+
+// takesValue reports whether option is followed by its value, like -u in sudo -u root
+// or in a combined sudo -nu root
+func takesValue(option string, valueOptions []string) bool {
+	if slices.Contains(valueOptions, option) {
+		return true
+	}
+	if strings.HasPrefix(option, "--") || len(option) < 3 || strings.Contains(option, "=") {
+		return false
+	}
+	return slices.Contains(valueOptions, "-"+option[len(option)-1:])
+}
+
+// Unwrap removes all wrappers from the front of args, so the first argument is the
+// program that runs
+func Unwrap(args []string) []string {
+	for len(args) > 0 && IsWrapper(args[0]) {
+		args = SkipWrapper(args)
+	}
+	return args
+}
@@ -0,0 +1,183 @@
+package sysinfo
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// packageManagers are looked up on PATH, most specific to the system first
+var packageManagers = []string{
+	"apt", "dnf", "yum", "zypper", "pacman", "apk", "emerge", "xbps-install",
+	"brew", "port", "nix", "snap", "flatpak", "winget", "choco", "scoop",
+}
+
+// Info describes the machine kass runs on
+type Info struct {
+	GOOS            string
+	Distro          string // e.g. "Ubuntu 22.04.4 LTS" or "macOS 14.4"
+	PackageManagers []string
+	InitSystem      string // systemd, openrc, runit or launchd, if known
+	Container       string // docker, podman, kubernetes, lxc or another runtime, if inside a container
+	WSL             string // "WSL 1" or "WSL 2" when running under Windows Subsystem for Linux
+}
+
+// Detect inspects the running system
+func Detect() *Info {
+	info := &Info{GOOS: runtime.GOOS}
+
+	switch runtime.GOOS {
+	case "linux":
+		info.Distro = linuxDistro()
+		info.InitSystem = linuxInitSystem()
+		info.Container = linuxContainer()
+		info.WSL = wslVersion()
+	case "darwin":
+		info.Distro = "macOS"
+		if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil {
+			info.Distro += " " + strings.TrimSpace(string(out))
+		}
+		info.InitSystem = "launchd"
+	}
+
+	for _, name := range packageManagers {
+		if name == "yum" && slices.Contains(info.PackageManagers, "dnf") {
+			continue // yum is an alias for dnf on current Fedora and RHEL
+		}
+		if _, err := exec.LookPath(name); err == nil {
+			info.PackageManagers = append(info.PackageManagers, name)
+		}
+	}
+	return info
+}
+
+// Describe returns a description of the system for the LLM. override is the OS from
+// the config file; it replaces the detected name unless it is only the generic GOOS
+// value, so older config files with "linux" still get the detected distribution.
+func (i *Info) Describe(override string) string {
+	name := i.GOOS
+	if i.Distro != "" {
+		name = i.Distro + " (" + i.GOOS + ")"
+	}
+	if override != "" && !strings.EqualFold(override, i.GOOS) {
+		name = override
+	}
+
+	var details []string
+	if len(i.PackageManagers) > 0 {
+		details = append(details, "package managers: "+strings.Join(i.PackageManagers, ", "))
+	}
+	if i.InitSystem != "" {
+		details = append(details, "init system: "+i.InitSystem)
+	}
+	if i.Container != "" {
+		details = append(details, "running in a "+i.Container+" container")
+	}
+	if i.WSL != "" {
+		details = append(details, "running under "+i.WSL)
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return name + " [" + strings.Join(details, "; ") + "]"
+}
+
+// linuxDistro reads the distribution name and version from os-release
+func linuxDistro() string {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		fields := readOSRelease(path)
+		if fields == nil {
+			continue
+		}
+		if pretty := fields["PRETTY_NAME"]; pretty != "" {
+			return pretty
+		}
+		return strings.TrimSpace(fields["NAME"] + " " + fields["VERSION_ID"])
+	}
+	return ""
+}
+
+func readOSRelease(path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return fields
+}
+
+// linuxInitSystem recognizes the common init systems. Containers usually have none.
+func linuxInitSystem() string {
+	switch {
+	case exists("/run/systemd/system"):
+		return "systemd"
+	case exists("/run/openrc"):
+		return "openrc"
+	case exists("/run/runit"), exists("/etc/runit/runsvdir"):
+		return "runit"
+	}
+	return ""
+}
+
+// linuxContainer names the container runtime kass runs in, if any
+func linuxContainer() string {
+	switch {
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		return "kubernetes"
+	case exists("/.dockerenv"):
+		return "docker"
+	case exists("/run/.containerenv"):
+		return "podman"
+	}
+
+	// systemd-nspawn, lxc and podman set $container for the init process
+	if runtimeName := os.Getenv("container"); runtimeName != "" {
+		return runtimeName
+	}
+	if data, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		cgroup := string(data)
+		switch {
+		case strings.Contains(cgroup, "kubepods"):
+			return "kubernetes"
+		case strings.Contains(cgroup, "docker"):
+			return "docker"
+		case strings.Contains(cgroup, "lxc"):
+			return "lxc"
+		}
+	}
+	return ""
+}
+
+// wslVersion reports whether the Linux kernel belongs to Windows Subsystem for Linux
+func wslVersion() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	release := strings.ToLower(string(data))
+	switch {
+	case strings.Contains(release, "wsl2"), strings.Contains(release, "microsoft-standard"):
+		return "WSL 2"
+	case strings.Contains(release, "microsoft"):
+		return "WSL 1"
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyTools are the programs listed in the prompt so the LLM knows what it can rely on
+var keyTools = []string{
+	"git", "curl", "wget", "jq", "yq", "rg", "fd", "fzf", "bat", "tree", "rsync", "ssh",
+	"make", "just", "docker", "podman", "kubectl", "helm", "terraform",
+	"python3", "pip3", "node", "npm", "pnpm", "yarn", "go", "cargo", "java", "gcc", "clang",
+	"ffmpeg", "convert", "gh", "tmux", "unzip", "7z", "sqlite3", "psql", "mysql",
+	"aws", "gcloud", "az",
+}
+
+// inventoryTTL is how long a cached inventory is trusted
+const inventoryTTL = 24 * time.Hour
+
+// Inventory records which of the key tools are installed
+type Inventory struct {
+	Path      string    `json:"path"` // PATH when the inventory was taken
+	Checked   time.Time `json:"checked"`
+	Installed []string  `json:"installed"`
+}
+
+// LoadInventory returns which key tools are installed. The result is cached in the
+// user cache directory for a day, or until PATH changes.
+func LoadInventory() *Inventory {
+	cachePath := ""
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		cachePath = filepath.Join(cacheDir, "kass", "tools.json")
+	}
+
+	currentPath := os.Getenv("PATH")
+	if cachePath != "" {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			var cached Inventory
+			if json.Unmarshal(data, &cached) == nil && cached.Path == currentPath && time.Since(cached.Checked) < inventoryTTL {
+				return &cached
+			}
+		}
+	}
+
+	inv := &Inventory{Path: currentPath, Checked: time.Now()}
+	for _, name := range keyTools {
+		if _, err := exec.LookPath(name); err == nil {
+			inv.Installed = append(inv.Installed, name)
+		}
+	}
+
+	// A missing cache only costs a few lookups next time, so errors are ignored
+	if cachePath != "" {
+		if data, err := json.Marshal(inv); err == nil {
+			if os.MkdirAll(filepath.Dir(cachePath), 0755) == nil {
+				_ = os.WriteFile(cachePath, data, 0644)
+			}
+		}
+	}
+	return inv
+}
+
+// String lists the installed and missing key tools for the system prompt
+func (inv *Inventory) String() string {
+	var missing []string
+	for _, name := range keyTools {
+		if !slices.Contains(inv.Installed, name) {
+			missing = append(missing, name)
+		}
+	}
+
+	s := "Installed: " + strings.Join(inv.Installed, ", ")
+	if len(missing) > 0 {
+		s += "\nNot installed: " + strings.Join(missing, ", ")
+	}
+	return s
+}
+
+// IsKeyTool reports whether name is one of the well-known programs kass checks for
+func IsKeyTool(name string) bool {
+	return slices.Contains(keyTools, name)
+}
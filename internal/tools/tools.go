@@ -0,0 +1,281 @@
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/evesfect/k-assist/internal/llm"
+	"github.com/evesfect/k-assist/internal/shellcmd"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// builtins are the builtins and keywords of bash and zsh, which never need to be installed
+var builtins = map[string]bool{
+	".": true, ":": true, "[": true, "[[": true, "alias": true, "bg": true, "bind": true,
+	"break": true, "builtin": true, "caller": true, "cd": true, "command": true,
+	"compgen": true, "complete": true, "compopt": true, "continue": true, "declare": true,
+	"dirs": true, "disown": true, "echo": true, "enable": true, "eval": true, "exec": true,
+	"exit": true, "export": true, "false": true, "fc": true, "fg": true, "getopts": true,
+	"hash": true, "help": true, "history": true, "jobs": true, "kill": true, "let": true,
+	"local": true, "logout": true, "mapfile": true, "popd": true, "printf": true,
+	"pushd": true, "pwd": true, "read": true, "readarray": true, "readonly": true,
+	"return": true, "set": true, "shift": true, "shopt": true, "source": true,
+	"suspend": true, "test": true, "time": true, "times": true, "trap": true, "true": true,
+	"type": true, "typeset": true, "ulimit": true, "umask": true, "unalias": true,
+	"unset": true, "wait": true,
+	// zsh
+	"autoload": true, "bindkey": true, "emulate": true, "noglob": true, "print": true,
+	"rehash": true, "setopt": true, "unsetopt": true, "whence": true, "where": true,
+	"zmodload": true, "zstyle": true,
+}
+
+// installers are package managers whose arguments name things being installed
+var installers = map[string]bool{
+	"apt": true, "apt-get": true, "dnf": true, "yum": true, "zypper": true, "pacman": true,
+	"apk": true, "brew": true, "port": true, "nix": true, "nix-env": true, "snap": true,
+	"pip": true, "pip3": true, "pipx": true, "uv": true, "npm": true, "pnpm": true,
+	"yarn": true, "cargo": true, "go": true, "gem": true, "winget": true, "choco": true,
+	"scoop": true,
+}
+
+// packageNames maps executables to the package that provides them, where it differs.
+// The "" key is the default for package managers without an entry of their own.
+var packageNames = map[string]map[string]string{
+	"rg":      {"": "ripgrep"},
+	"fd":      {"": "fd", "apt": "fd-find", "dnf": "fd-find"},
+	"ag":      {"": "the_silver_searcher", "apt": "silversearcher-ag"},
+	"http":    {"": "httpie"},
+	"delta":   {"": "git-delta"},
+	"7z":      {"": "p7zip", "apt": "p7zip-full"},
+	"convert": {"": "imagemagick", "dnf": "ImageMagick"},
+	"magick":  {"": "imagemagick", "dnf": "ImageMagick"},
+	"pip3":    {"": "python3-pip", "brew": "python", "pacman": "python-pip", "apk": "py3-pip"},
+	"python3": {"": "python3", "brew": "python", "pacman": "python"},
+	"node":    {"": "nodejs", "brew": "node"},
+	"btm":     {"": "bottom"},
+	"dig":     {"": "dnsutils", "dnf": "bind-utils", "pacman": "bind", "apk": "bind-tools", "brew": "bind"},
+	"nc":      {"": "netcat-openbsd", "dnf": "nmap-ncat", "pacman": "openbsd-netcat", "brew": "netcat"},
+}
+
+// installCommands are the commands that install a package with each package manager
+var installCommands = map[string]string{
+	"apt":    "sudo apt install %s",
+	"dnf":    "sudo dnf install %s",
+	"yum":    "sudo yum install %s",
+	"zypper": "sudo zypper install %s",
+	"pacman": "sudo pacman -S %s",
+	"apk":    "sudo apk add %s",
+	"brew":   "brew install %s",
+	"port":   "sudo port install %s",
+	"nix":    "nix profile install nixpkgs#%s",
+	"winget": "winget install %s",
+	"choco":  "choco install %s",
+	"scoop":  "scoop install %s",
+}
+
+// Executables returns the programs a shell command runs, skipping builtins and
+// functions it defines. Commands that can't be parsed have no executables.
+func Executables(command string) []string {
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil
+	}
+
+	functions := make(map[string]bool)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if decl, ok := node.(*syntax.FuncDecl); ok {
+			functions[decl.Name.Value] = true
+		}
+		return true
+	})
+
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] && !builtins[name] && !functions[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+		args := literalArgs(call)
+		for len(args) > 0 {
+			add(args[0])
+			if !shellcmd.IsWrapper(args[0]) {
+				break
+			}
+			args = shellcmd.SkipWrapper(args)
+		}
+		return true
+	})
+	return names
+}
+
+// Calls returns the literal arguments of every simple command in a shell command,
+// with wrappers like sudo removed so the first argument is the program that runs
+func Calls(command string) [][]string {
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil
+	}
+
+	var calls [][]string
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if call, ok := node.(*syntax.CallExpr); ok {
+			if args := shellcmd.Unwrap(literalArgs(call)); len(args) > 0 {
+				calls = append(calls, args)
+			}
+		}
+		return true
+	})
+	return calls
+}
+
+// literalArgs returns the leading arguments of a call that are plain words, with
+// quotes removed. Words with expansions, like $EDITOR, end the list because their
+// value isn't known.
+func literalArgs(call *syntax.CallExpr) []string {
+	var args []string
+	for _, word := range call.Args {
+		lit, ok := literal(word)
+		if !ok {
+			break
+		}
+		args = append(args, lit)
+	}
+	return args
+}
+
+func literal(word *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range p.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// installed reports whether an executable is on PATH. Programs given by their path,
+// like ./build.sh, are assumed to exist since an earlier step may create them.
+func installed(name string) bool {
+	if strings.Contains(name, "/") {
+		return true
+	}
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// Check annotates suggestions with the executables they need that aren't installed.
+// Programs installed by an earlier suggestion, like jq after "apt install jq", don't
+// count as missing. managers are the available package managers, used for install hints.
+// It returns the names of all missing executables.
+func Check(suggestions []llm.Suggestion, managers []string) []string {
+	willInstall := make(map[string]bool)
+	var all []string
+	for i := range suggestions {
+		for _, name := range installTargets(suggestions[i].Command) {
+			willInstall[name] = true
+		}
+
+		suggestions[i].Missing = nil
+		for _, name := range Executables(suggestions[i].Command) {
+			if willInstall[name] || installed(name) {
+				continue
+			}
+			suggestions[i].Missing = append(suggestions[i].Missing, llm.MissingTool{
+				Name:    name,
+				Install: InstallHint(name, managers),
+			})
+			all = append(all, name)
+		}
+	}
+	return all
+}
+
+// installTargets returns the arguments of package manager calls in a command, which
+// are the names of what it installs (along with some subcommands, which is harmless)
+func installTargets(command string) []string {
+	var targets []string
+	for _, args := range Calls(command) {
+		if !installers[path.Base(args[0])] {
+			continue
+		}
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			// Package specs like nixpkgs#jq, ripgrep@14 or github.com/x/tool@latest
+			name := arg
+			if i := strings.LastIndexAny(name, "#/"); i >= 0 {
+				name = name[i+1:]
+			}
+			name, _, _ = strings.Cut(name, "@")
+			targets = append(targets, name)
+			for tool, packages := range packageNames {
+				for _, pkg := range packages {
+					if pkg == name {
+						targets = append(targets, tool)
+					}
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// InstallHint returns a command that installs an executable with the first of the
+// package managers that can, or "" if none is available
+func InstallHint(name string, managers []string) string {
+	if strings.Contains(name, "/") {
+		return ""
+	}
+	for _, manager := range managers {
+		format, ok := installCommands[manager]
+		if !ok {
+			continue
+		}
+		pkg := name
+		if names, ok := packageNames[name]; ok {
+			if p, ok := names[manager]; ok {
+				pkg = p
+			} else {
+				pkg = names[""]
+			}
+		}
+		return fmt.Sprintf(format, pkg)
+	}
+	return ""
+}
+
+// AlternativesPrompt asks the LLM to avoid the missing executables when it suggests commands again
+func AlternativesPrompt(missing []string) string {
+	sorted := append([]string{}, missing...)
+	sort.Strings(sorted)
+	return fmt.Sprintf(
+		"These programs are not installed on this machine: %s. "+
+			"Suggest commands that use installed programs instead, or include a step that installs them if there is no alternative.",
+		strings.Join(sorted, ", "),
+	)
+}